@@ -4,10 +4,17 @@ import (
 	"context"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ansarctica/domashka4/internal/handlers"
+	"github.com/ansarctica/domashka4/internal/jwtkeys"
+	"github.com/ansarctica/domashka4/internal/mailer"
+	"github.com/ansarctica/domashka4/internal/models"
 	"github.com/ansarctica/domashka4/internal/postgres"
 	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/ansarctica/domashka4/internal/sso"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
@@ -24,6 +31,10 @@ import (
 // @securityDefinitions.apikey BearerAuth
 // @in header
 // @name Authorization
+
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
 func main() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("Did't find .env file")
@@ -43,11 +54,127 @@ func main() {
 	}
 	defer dbPool.Close()
 
+	var m mailer.Mailer
+	if os.Getenv("MAILER") == "smtp" {
+		m = mailer.NewSMTPMailer(
+			os.Getenv("SMTP_HOST"),
+			os.Getenv("SMTP_PORT"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			os.Getenv("SMTP_FROM"),
+		)
+	} else {
+		m = mailer.NewLogMailer(os.Getenv("MAIL_LOG_FILE"))
+	}
+
+	keyConfig := jwtkeys.Config{
+		Secret:      os.Getenv("SECRET_KEY"),
+		Algorithm:   os.Getenv("JWT_ALGORITHM"),
+		GracePeriod: time.Hour,
+	}
+	for _, path := range strings.Split(os.Getenv("JWT_PRIVATE_KEY_FILES"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			keyConfig.KeyFiles = append(keyConfig.KeyFiles, path)
+		}
+	}
+	if v := os.Getenv("JWT_ROTATION_INTERVAL"); v != "" {
+		if keyConfig.RotationInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatal("Invalid JWT_ROTATION_INTERVAL: ", err)
+		}
+	}
+	if v := os.Getenv("JWT_GRACE_PERIOD"); v != "" {
+		if keyConfig.GracePeriod, err = time.ParseDuration(v); err != nil {
+			log.Fatal("Invalid JWT_GRACE_PERIOD: ", err)
+		}
+	}
+
 	repo := postgres.NewRepository(dbPool)
-	srv := service.NewService(repo)
+
+	keys, err := jwtkeys.NewManager(context.Background(), keyConfig, repo)
+	if err != nil {
+		log.Fatal("Didn't load JWT keys: ", err)
+	}
+	go keys.Run(context.Background())
+
+	// Single sign-on is on when an issuer is configured. OIDC_ROLE_MAP takes
+	// comma separated value=role pairs matched against the OIDC_ROLE_CLAIM
+	// claim; users matching none get OIDC_DEFAULT_ROLE, or are refused
+	// when it is empty.
+	var provider *sso.Provider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		ssoConfig := sso.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+			RoleClaim:    os.Getenv("OIDC_ROLE_CLAIM"),
+			RoleMap:      make(map[string]string),
+			DefaultRole:  os.Getenv("OIDC_DEFAULT_ROLE"),
+		}
+		for _, pair := range strings.Split(os.Getenv("OIDC_ROLE_MAP"), ",") {
+			value, role, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			if !service.ValidRole(role) {
+				log.Fatal("Unknown role in OIDC_ROLE_MAP: ", role)
+			}
+			ssoConfig.RoleMap[value] = role
+		}
+		if ssoConfig.DefaultRole != "" && !service.ValidRole(ssoConfig.DefaultRole) {
+			log.Fatal("Unknown OIDC_DEFAULT_ROLE: ", ssoConfig.DefaultRole)
+		}
+
+		provider, err = sso.NewProvider(context.Background(), ssoConfig)
+		if err != nil {
+			log.Fatal("Didn't reach the OIDC provider: ", err)
+		}
+	}
+
+	minLength, minClasses := 8, 1
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		if minLength, err = strconv.Atoi(v); err != nil {
+			log.Fatal("Invalid PASSWORD_MIN_LENGTH: ", err)
+		}
+	}
+	if v := os.Getenv("PASSWORD_MIN_CLASSES"); v != "" {
+		if minClasses, err = strconv.Atoi(v); err != nil {
+			log.Fatal("Invalid PASSWORD_MIN_CLASSES: ", err)
+		}
+	}
+	policy, err := service.NewPasswordPolicy(minLength, minClasses, os.Getenv("PASSWORD_BLOCKLIST_FILE"))
+	if err != nil {
+		log.Fatal("Didn't load the password blocklist: ", err)
+	}
+
+	// TOTP_REQUIRED_ROLES takes a comma separated list of roles that must
+	// use two-factor authentication.
+	var requiredRoles []string
+	for _, role := range strings.Split(os.Getenv("TOTP_REQUIRED_ROLES"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			requiredRoles = append(requiredRoles, role)
+		}
+	}
+	twoFactor, err := service.NewTwoFactorPolicy(os.Getenv("TOTP_ISSUER"), requiredRoles)
+	if err != nil {
+		log.Fatal("Invalid TOTP_REQUIRED_ROLES: ", err)
+	}
+
+	// Emails link to the frontend for password resets and invitations, and
+	// to the API itself for email verification.
+	links, err := service.NewLinks(os.Getenv("APP_BASE_URL"), os.Getenv("API_BASE_URL"))
+	if err != nil {
+		log.Fatal("Invalid APP_BASE_URL or API_BASE_URL: ", err)
+	}
+
+	srv := service.NewService(repo, m, keys, provider, policy, twoFactor, links)
 	h := handlers.NewHandler(srv)
 
 	e := echo.New()
+	// Only trust X-Forwarded-For when it was set by a proxy on a private
+	// network, so clients cannot pick the IP that login throttling sees.
+	e.IPExtractor = echo.ExtractIPFromXFFHeader()
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"http://localhost:5173", "https://front-for-hw4.onrender.com"},
@@ -56,6 +183,7 @@ func main() {
 			echo.HeaderContentType,
 			echo.HeaderAccept,
 			echo.HeaderAuthorization,
+			"X-API-Key",
 		},
 		AllowMethods: []string{
 			echo.GET,
@@ -72,33 +200,103 @@ func main() {
 
 	// Swagger Endpoint
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
+	e.GET("/.well-known/jwks.json", h.JWKS)
 
 	auth := e.Group("/auth")
 	auth.POST("/register", h.Register)
 	auth.POST("/login", h.Login)
+	auth.POST("/login/2fa", h.LoginTwoFactor)
+	auth.POST("/refresh", h.Refresh)
+	auth.POST("/logout", h.Logout, h.UserIdentity)
+	auth.GET("/verify", h.VerifyEmail)
+	auth.POST("/verify/resend", h.ResendVerification)
+	auth.POST("/password/forgot", h.ForgotPassword)
+	auth.POST("/password/reset", h.ResetPassword)
+	auth.POST("/guardian/accept", h.AcceptGuardianInvitation)
+	auth.GET("/oidc/login", h.OIDCLogin)
+	auth.GET("/oidc/callback", h.OIDCCallback)
 
-	protected := e.Group("", h.UserIdentity)
-	protected.GET("/users/me", h.GetMe)
-	protected.GET("/students", h.GetStudents)
-	protected.GET("/students/:id", h.GetStudent)
-	protected.POST("/students", h.CreateStudent)
-	protected.PATCH("/students/:id", h.UpdateStudent)
-	protected.DELETE("/students/:id", h.DeleteStudent)
-	protected.GET("/students/:id/gpa", h.GetStudentGPA)
+	// Routes on account stay open to users who still have to enroll in
+	// two-factor authentication, everything under protected does not.
+	account := e.Group("", h.UserIdentity)
+	account.GET("/users/me", h.GetMe)
+	account.POST("/users/me/2fa", h.EnrollTwoFactor)
+	account.POST("/users/me/2fa/confirm", h.ConfirmTwoFactor)
+	account.DELETE("/users/me/2fa", h.DisableTwoFactor)
+	account.POST("/users/me/2fa/recovery-codes", h.RegenerateRecoveryCodes)
+
+	protected := account.Group("", h.RequireTwoFactor)
+	protected.GET("/users/me/api-keys", h.GetAPIKeys)
+	protected.POST("/users/me/api-keys", h.CreateAPIKey)
+	protected.DELETE("/users/me/api-keys/:id", h.RevokeAPIKey)
+	protected.PUT("/users/me/password", h.ChangePassword)
+	protected.GET("/users/me/sessions", h.GetMySessions)
+	protected.DELETE("/users/me/sessions/:id", h.TerminateMySession)
 	protected.GET("/groups", h.GetGroups)
 	protected.GET("/schedules", h.GetSchedules)
-	protected.POST("/schedules", h.CreateSchedule)
-	protected.PATCH("/schedules/:id", h.UpdateSchedule)
-	protected.DELETE("/schedules/:id", h.DeleteSchedule)
-	protected.GET("/attendance", h.GetAttendance)
-	protected.POST("/attendance", h.CreateAttendance)
-	protected.PATCH("/attendance/:id", h.UpdateAttendance)
-	protected.DELETE("/attendance/:id", h.DeleteAttendance)
-	protected.GET("/assignments", h.GetAssignments)
-	protected.POST("/assignments", h.CreateAssignment)
-	protected.POST("/grades", h.CreateGrade)
-	protected.GET("/rankings", h.GetRankings)
 	protected.GET("/subjects", h.GetSubjects)
 
+	me := protected.Group("/me")
+	me.GET("/grades", h.GetMyGrades)
+	me.GET("/attendance", h.GetMyAttendance)
+	me.GET("/schedule", h.GetMySchedule)
+	me.GET("/gpa", h.GetMyGPA)
+
+	guardian := protected.Group("", h.RequireRole(models.RoleParent))
+	guardian.GET("/guardians/me/students", h.GetGuardianStudents)
+
+	// Guardians reach these too; the service limits them to their own students.
+	readers := protected.Group("", h.RequireRole(models.RoleAdmin, models.RoleTeacher, models.RoleParent))
+	readers.GET("/students/:id", h.GetStudent)
+	readers.GET("/students/:id/gpa", h.GetStudentGPA)
+	readers.GET("/students/:id/schedule", h.GetStudentSchedule)
+	readers.GET("/students/:id/group-history", h.GetStudentGroupHistory)
+	readers.GET("/students/:id/status-history", h.GetStudentStatusHistory)
+	readers.GET("/attendance", h.GetAttendance)
+
+	staff := protected.Group("", h.RequireRole(models.RoleAdmin, models.RoleTeacher))
+	staff.GET("/students", h.GetStudents)
+	staff.GET("/students/search/suggest", h.SuggestStudents)
+	staff.GET("/students/export", h.ExportStudents)
+	staff.POST("/attendance", h.CreateAttendance)
+	staff.PATCH("/attendance/:id", h.UpdateAttendance)
+	staff.DELETE("/attendance/:id", h.DeleteAttendance)
+	staff.GET("/assignments", h.GetAssignments)
+	staff.POST("/assignments", h.CreateAssignment)
+	staff.POST("/grades", h.CreateGrade)
+	staff.GET("/rankings", h.GetRankings)
+	staff.GET("/teachers/me", h.GetMyTeacherProfile)
+	staff.POST("/guardians/invitations", h.InviteGuardian)
+
+	admin := protected.Group("", h.RequireRole(models.RoleAdmin))
+	admin.POST("/students", h.CreateStudent)
+	admin.POST("/students/import", h.ImportStudents)
+	admin.PATCH("/students/:id", h.UpdateStudent)
+	admin.DELETE("/students/:id", h.DeleteStudent)
+	admin.POST("/students/:id/restore", h.RestoreStudent)
+	admin.DELETE("/students/:id/purge", h.PurgeStudent)
+	admin.POST("/students/:id/transfer", h.TransferStudent)
+	admin.POST("/students/:id/status", h.ChangeStudentStatus)
+	admin.POST("/schedules", h.CreateSchedule)
+	admin.PATCH("/schedules/:id", h.UpdateSchedule)
+	admin.DELETE("/schedules/:id", h.DeleteSchedule)
+	admin.GET("/users", h.GetUsers)
+	admin.GET("/users/:id", h.GetUser)
+	admin.DELETE("/users/:id", h.DeleteUser)
+	admin.PUT("/users/:id/role", h.ChangeUserRole)
+	admin.POST("/users/:id/disable", h.DisableUser)
+	admin.POST("/users/:id/enable", h.EnableUser)
+	admin.POST("/users/:id/password-reset", h.ForceUserPasswordReset)
+	admin.GET("/audit-log", h.GetAuditLog)
+	admin.POST("/users/:id/revoke-tokens", h.RevokeUserTokens)
+	admin.GET("/users/:id/sessions", h.GetUserSessions)
+	admin.DELETE("/users/:id/sessions/:sessionId", h.TerminateUserSession)
+	admin.POST("/users/:id/unlock", h.UnlockUser)
+	admin.PUT("/users/:id/student", h.LinkUserStudent)
+	admin.GET("/teachers", h.GetTeachers)
+	admin.POST("/teachers", h.CreateTeacher)
+	admin.POST("/teachers/:id/classes", h.AddTeachingClass)
+	admin.DELETE("/teachers/:id/classes", h.RemoveTeachingClass)
+
 	e.Logger.Fatal(e.Start(port))
 }
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type APIKeyInput struct {
+	Name   string   `json:"name"`
+	Role   string   `json:"role"`
+	Scopes []string `json:"scopes"`
+}
+
+type CreatedAPIKey struct {
+	Key    string        `json:"key"`
+	APIKey models.APIKey `json:"api_key"`
+}
+
+// CreateAPIKey issues an API key
+// @Summary Create API key
+// @Description Create an API key for machine clients, sent in the X-API-Key header. The key is bound to a role and to the route groups listed in scopes (students, groups, schedules, attendance, assignments, grades, rankings, subjects). The plain key is only returned once.
+// @Tags API Keys
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param input body handlers.APIKeyInput true "API Key Data"
+// @Success 201 {object} handlers.CreatedAPIKey
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/api-keys [post]
+func (h *Handler) CreateAPIKey(c echo.Context) error {
+	userID, ok := c.Get("userId").(int)
+	if !ok {
+		return JSON(c, http.StatusInternalServerError, errors.New("no user id"))
+	}
+
+	var input APIKeyInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	key, plain, err := h.service.CreateAPIKey(c.Request().Context(), userID, input.Name, input.Role, input.Scopes)
+	switch {
+	case errors.Is(err, service.ErrInvalidAPIKeyData), errors.Is(err, service.ErrUnknownRole):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrForbidden):
+		return JSON(c, http.StatusForbidden, errors.New("api key role must match your own role"))
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusCreated, CreatedAPIKey{Key: plain, APIKey: *key})
+}
+
+// GetAPIKeys lists the caller's API keys
+// @Summary List API keys
+// @Description List the API keys of the current user, including revoked ones
+// @Tags API Keys
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Success 200 {array} models.APIKey
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/api-keys [get]
+func (h *Handler) GetAPIKeys(c echo.Context) error {
+	userID, ok := c.Get("userId").(int)
+	if !ok {
+		return JSON(c, http.StatusInternalServerError, errors.New("no user id"))
+	}
+
+	keys, err := h.service.GetAPIKeys(c.Request().Context(), userID)
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey revokes one of the caller's API keys
+// @Summary Revoke API key
+// @Description Revoke an API key of the current user
+// @Tags API Keys
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "API Key ID"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/api-keys/{id} [delete]
+func (h *Handler) RevokeAPIKey(c echo.Context) error {
+	userID, ok := c.Get("userId").(int)
+	if !ok {
+		return JSON(c, http.StatusInternalServerError, errors.New("no user id"))
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.RevokeAPIKey(c.Request().Context(), userID, id)
+	if errors.Is(err, service.ErrAPIKeyNotFound) {
+		return JSON(c, http.StatusNotFound, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "revoked"})
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/service"
 	"github.com/labstack/echo/v4"
 )
 
@@ -18,28 +21,52 @@ type AttendanceInput struct {
 
 // GetAttendance retrieves attendance records
 // @Summary Get attendance
-// @Description Get attendance records filtered by student ID or subject name
+// @Description Get attendance records filtered by student ID or subject name. Teachers may omit both to get the attendance of their own classes. Guardians must filter by one of their own students. Records of students who are not enrolled are left out unless include_inactive is set or a student_id is given.
 // @Tags Attendance
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param student_id query int false "Filter by Student ID"
 // @Param subject_name query string false "Filter by Subject Name"
-// @Success 200 {array} models.Attendance
+// @Param include_inactive query bool false "Include records of students who are not enrolled"
+// @Param sort query string false "Comma separated columns to sort by, - in front for descending: id, subject_name, visit_day, visited, student_id"
+// @Param cursor query string false "next_cursor or prev_cursor of another page"
+// @Param limit query int false "Page size (default 20, at most 100)"
+// @Success 200 {object} models.Page[models.Attendance]
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /attendance [get]
 func (h *Handler) GetAttendance(c echo.Context) error {
 	var params struct {
-		StudentID   *int    `query:"student_id"`
-		SubjectName *string `query:"subject_name"`
+		StudentID       *int    `query:"student_id"`
+		SubjectName     *string `query:"subject_name"`
+		IncludeInactive bool    `query:"include_inactive"`
+		Sort            string  `query:"sort"`
+		Cursor          string  `query:"cursor"`
+		Limit           int     `query:"limit"`
 	}
 
 	if err := c.Bind(&params); err != nil {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	attendanceList, err := h.service.GetAttendance(c.Request().Context(), params.StudentID, params.SubjectName)
+	filter := models.AttendanceFilter{
+		StudentID:       params.StudentID,
+		SubjectName:     params.SubjectName,
+		IncludeInactive: params.IncludeInactive || params.StudentID != nil,
+		Sort:            params.Sort,
+		Page:            models.PageRequest{Cursor: params.Cursor, Limit: params.Limit},
+	}
+
+	attendanceList, err := h.service.GetAttendance(c.Request().Context(), identity(c), filter)
+	if errors.Is(err, service.ErrInvalidCursor) || errors.Is(err, service.ErrInvalidSort) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	if errors.Is(err, service.ErrForbidden) || errors.Is(err, service.ErrNoTeacherProfile) {
+		return JSON(c, http.StatusForbidden, err)
+	}
 	if err != nil {
 		return JSON(c, http.StatusInternalServerError, err)
 	}
@@ -49,14 +76,17 @@ func (h *Handler) GetAttendance(c echo.Context) error {
 
 // CreateAttendance records a new attendance entry
 // @Summary Record attendance
-// @Description Create a new attendance record for a student
+// @Description Create a new attendance record for an enrolled student
 // @Tags Attendance
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param input body handlers.AttendanceInput true "Attendance Data"
 // @Success 201 {object} map[string]int "Returns created ID"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse "The student is not enrolled"
 // @Failure 500 {object} models.ErrorResponse
 // @Router /attendance [post]
 func (h *Handler) CreateAttendance(c echo.Context) error {
@@ -78,8 +108,15 @@ func (h *Handler) CreateAttendance(c echo.Context) error {
 		StudentID:   input.StudentID,
 	}
 
-	id, err := h.service.NewAttendance(c.Request().Context(), attendance)
-	if err != nil {
+	id, err := h.service.NewAttendance(c.Request().Context(), identity(c), attendance)
+	switch {
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, service.ErrNoTeacherProfile):
+		return JSON(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrStudentInactive):
+		return JSON(c, http.StatusConflict, err)
+	case err != nil:
 		return JSON(c, http.StatusInternalServerError, err)
 	}
 
@@ -88,15 +125,20 @@ func (h *Handler) CreateAttendance(c echo.Context) error {
 
 // UpdateAttendance modifies an attendance record
 // @Summary Update attendance
-// @Description Update an existing attendance record
+// @Description Update the given fields of an attendance record with a JSON merge patch (RFC 7396), or a JSON Patch (RFC 6902) sent as application/json-patch+json. Fields left out keep their values, and the result must still be a valid record.
 // @Tags Attendance
 // @Security BearerAuth
-// @Accept json
+// @Security ApiKeyAuth
+// @Accept json,application/merge-patch+json,application/json-patch+json
 // @Produce json
 // @Param id path int true "Attendance ID"
-// @Param input body handlers.AttendanceInput true "Updated Attendance Data"
+// @Param input body handlers.AttendanceInput true "Fields to change"
 // @Success 200 {object} map[string]string "Returns status"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse "A JSON Patch test operation failed"
+// @Failure 415 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /attendance/{id} [patch]
 func (h *Handler) UpdateAttendance(c echo.Context) error {
@@ -105,25 +147,47 @@ func (h *Handler) UpdateAttendance(c echo.Context) error {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	var input AttendanceInput
-	if err := c.Bind(&input); err != nil {
-		return JSON(c, http.StatusBadRequest, err)
+	patchInput, err := readPatch[AttendanceInput](c)
+	if err == nil {
+		// The record is patched in the shape it is created in.
+		patch := func(a *models.Attendance) error {
+			input := AttendanceInput{
+				SubjectName: a.SubjectName,
+				VisitDay:    a.VisitDay.Format("02.01.2006"),
+				Visited:     a.Visited,
+				StudentID:   a.StudentID,
+			}
+			if err := patchInput(&input); err != nil {
+				return err
+			}
+
+			parsedDate, err := time.Parse("02.01.2006", input.VisitDay)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errInvalidPatch, err)
+			}
+
+			a.SubjectName = input.SubjectName
+			a.VisitDay = parsedDate
+			a.Visited = input.Visited
+			a.StudentID = input.StudentID
+			return nil
+		}
+		err = h.service.UpdateAttendance(c.Request().Context(), identity(c), id, patch)
 	}
-
-	parsedDate, err := time.Parse("02.01.2006", input.VisitDay)
-	if err != nil {
+	switch {
+	case errors.Is(err, errUnsupportedPatch):
+		return JSON(c, http.StatusUnsupportedMediaType, err)
+	case errors.Is(err, errInvalidPatch), errors.Is(err, service.ErrInvalidAttendance), errors.Is(err, service.ErrInvalidReference):
 		return JSON(c, http.StatusBadRequest, err)
-	}
-
-	attendance := &models.Attendance{
-		ID:          id,
-		SubjectName: input.SubjectName,
-		VisitDay:    parsedDate,
-		Visited:     input.Visited,
-		StudentID:   input.StudentID,
-	}
-
-	if err := h.service.UpdateAttendance(c.Request().Context(), attendance); err != nil {
+	case errors.Is(err, errPatchTestFailed):
+		return JSON(c, http.StatusConflict, err)
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, service.ErrNoTeacherProfile):
+		return JSON(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrAttendanceNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusBadRequest, err)
+	case err != nil:
 		return JSON(c, http.StatusInternalServerError, err)
 	}
 	return c.JSON(http.StatusOK, map[string]string{"status": "updated"})
@@ -134,11 +198,13 @@ func (h *Handler) UpdateAttendance(c echo.Context) error {
 // @Description Remove an attendance record from the database
 // @Tags Attendance
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param id path int true "Attendance ID"
 // @Success 200 {object} map[string]string "Returns status"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /attendance/{id} [delete]
 func (h *Handler) DeleteAttendance(c echo.Context) error {
@@ -147,7 +213,13 @@ func (h *Handler) DeleteAttendance(c echo.Context) error {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	if err := h.service.DeleteAttendance(c.Request().Context(), id); err != nil {
+	err = h.service.DeleteAttendance(c.Request().Context(), identity(c), id)
+	switch {
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, service.ErrNoTeacherProfile):
+		return JSON(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrAttendanceNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case err != nil:
 		return JSON(c, http.StatusInternalServerError, err)
 	}
 	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
@@ -4,14 +4,46 @@ import (
 	"errors"
 	"net/http"
 	"net/mail"
+	"strconv"
 
 	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/service"
 	"github.com/labstack/echo/v4"
 )
 
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ForgotPasswordInput struct {
+	Email string `json:"email"`
+}
+
+type LinkStudentInput struct {
+	StudentID *int `json:"student_id"`
+}
+
+type ResendVerificationInput struct {
+	Email string `json:"email"`
+}
+
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ResetPasswordInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
 // Register creates a new user
 // @Summary Register a new user
-// @Description Register a new user with email and password
+// @Description Register a new user with email and password. The password must meet the password policy. Self-registered accounts always get the student role and must confirm their email before logging in.
 // @Tags Auth
 // @Accept json
 // @Produce json
@@ -31,7 +63,13 @@ func (h *Handler) Register(c echo.Context) error {
 		return JSON(c, http.StatusBadRequest, errors.New("wrong email format"))
 	}
 
+	input.Role = models.RoleStudent
+	input.EmailVerified = false
+
 	id, err := h.service.CreateUser(c.Request().Context(), &input)
+	if errors.Is(err, service.ErrWeakPassword) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
 	if err != nil {
 		return JSON(c, http.StatusInternalServerError, err)
 	}
@@ -43,14 +81,17 @@ func (h *Handler) Register(c echo.Context) error {
 
 // Login authenticates a user
 // @Summary Login
-// @Description Authenticate using email and password to receive a JWT token
+// @Description Authenticate using email and password to receive a short-lived JWT access token and a refresh token. Accounts with two-factor authentication instead get a challenge token for /auth/login/2fa
 // @Tags Auth
 // @Accept json
 // @Produce json
 // @Param input body models.User true "User Credentials"
-// @Success 200 {object} map[string]string "Returns JWT token"
+// @Success 200 {object} models.AuthTokens
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
 // @Router /auth/login [post]
 func (h *Handler) Login(c echo.Context) error {
 	var input models.User
@@ -59,19 +100,353 @@ func (h *Handler) Login(c echo.Context) error {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	token, err := h.service.GenerateToken(c.Request().Context(), input.Email, input.Password)
+	tokens, err := h.service.GenerateToken(c.Request().Context(), input.Email, input.Password, c.RealIP(), c.Request().UserAgent())
+	switch {
+	case errors.Is(err, service.ErrInvalidCredentials):
+		return JSON(c, http.StatusUnauthorized, err)
+	case errors.Is(err, service.ErrTooManyAttempts):
+		return JSON(c, http.StatusTooManyRequests, err)
+	case errors.Is(err, service.ErrEmailNotVerified), errors.Is(err, service.ErrAccountDisabled):
+		return JSON(c, http.StatusForbidden, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// VerifyEmail confirms a user's email address
+// @Summary Verify email
+// @Description Confirm an email address with the token from the verification email
+// @Tags Auth
+// @Produce json
+// @Param token query string true "Verification Token"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /auth/verify [get]
+func (h *Handler) VerifyEmail(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return JSON(c, http.StatusBadRequest, errors.New("token is required"))
+	}
+
+	err := h.service.VerifyEmail(c.Request().Context(), token)
+	if errors.Is(err, service.ErrInvalidVerificationToken) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
 	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "email verified"})
+}
+
+// ResendVerification sends a new verification email
+// @Summary Resend verification email
+// @Description Send a new verification link to an unverified account. The response is the same whether or not the email is registered.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param input body handlers.ResendVerificationInput true "Account Email"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /auth/verify/resend [post]
+func (h *Handler) ResendVerification(c echo.Context) error {
+	var input ResendVerificationInput
+
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	if _, err := mail.ParseAddress(input.Email); err != nil {
+		return JSON(c, http.StatusBadRequest, errors.New("wrong email format"))
+	}
+
+	if err := h.service.ResendVerification(c.Request().Context(), input.Email); err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "verification link sent if the account needs one"})
+}
+
+// Refresh rotates a refresh token
+// @Summary Refresh tokens
+// @Description Exchange a refresh token for a new access token and refresh token. Each refresh token works once; reusing one revokes every token issued from the same login.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param input body handlers.RefreshInput true "Refresh Token"
+// @Success 200 {object} models.AuthTokens
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /auth/refresh [post]
+func (h *Handler) Refresh(c echo.Context) error {
+	var input RefreshInput
+
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	if input.RefreshToken == "" {
+		return JSON(c, http.StatusBadRequest, errors.New("refresh_token is required"))
+	}
+
+	tokens, err := h.service.RefreshToken(c.Request().Context(), input.RefreshToken)
+	if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
 		return JSON(c, http.StatusUnauthorized, err)
 	}
+	if errors.Is(err, service.ErrAccountDisabled) {
+		return JSON(c, http.StatusForbidden, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"token": token,
-	})
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the current tokens
+// @Summary Logout
+// @Description Revoke the access token used for this request and, if supplied, the refresh token issued with it
+// @Tags Auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param input body handlers.LogoutInput false "Refresh Token"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /auth/logout [post]
+func (h *Handler) Logout(c echo.Context) error {
+	claims, ok := c.Get("claims").(*service.TokenClaims)
+	if !ok {
+		return JSON(c, http.StatusInternalServerError, errors.New("no token claims"))
+	}
+
+	var input LogoutInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	if err := h.service.Logout(c.Request().Context(), claims, input.RefreshToken); err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "logged out"})
+}
+
+// ForgotPassword starts a password reset
+// @Summary Request password reset
+// @Description Email a one-time password reset link. The response is the same whether or not the email is registered.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param input body handlers.ForgotPasswordInput true "Account Email"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /auth/password/forgot [post]
+func (h *Handler) ForgotPassword(c echo.Context) error {
+	var input ForgotPasswordInput
+
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	if _, err := mail.ParseAddress(input.Email); err != nil {
+		return JSON(c, http.StatusBadRequest, errors.New("wrong email format"))
+	}
+
+	if err := h.service.ForgotPassword(c.Request().Context(), input.Email); err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "reset link sent if the account exists"})
+}
+
+// ResetPassword sets a new password with a reset token
+// @Summary Reset password
+// @Description Set a new password using the token from the reset email. All existing sessions are signed out.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param input body handlers.ResetPasswordInput true "Reset Token and New Password"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /auth/password/reset [post]
+func (h *Handler) ResetPassword(c echo.Context) error {
+	var input ResetPasswordInput
+
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	if input.Token == "" || input.Password == "" {
+		return JSON(c, http.StatusBadRequest, errors.New("token and password are required"))
+	}
+
+	err := h.service.ResetPassword(c.Request().Context(), input.Token, input.Password)
+	if errors.Is(err, service.ErrInvalidResetToken) || errors.Is(err, service.ErrWeakPassword) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "password updated"})
+}
+
+// ChangePassword changes the current user's password
+// @Summary Change password
+// @Description Change the password of the current user, given the current one. The new password must meet the password policy. Every other session of the user is signed out
+// @Tags Auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param input body ChangePasswordInput true "Current and New Password"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/password [put]
+func (h *Handler) ChangePassword(c echo.Context) error {
+	var input ChangePasswordInput
+
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	var sessionID int
+	if claims, ok := c.Get("claims").(*service.TokenClaims); ok {
+		sessionID = claims.SessionID
+	}
+
+	err := h.service.ChangePassword(c.Request().Context(), identity(c).UserID, sessionID,
+		input.CurrentPassword, input.NewPassword, c.RealIP())
+	switch {
+	case errors.Is(err, service.ErrWrongPassword), errors.Is(err, service.ErrWeakPassword):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrTooManyAttempts):
+		return JSON(c, http.StatusTooManyRequests, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "password updated"})
+}
+
+// RevokeUserTokens revokes every token of a user
+// @Summary Revoke user tokens
+// @Description Invalidate every access and refresh token issued to a user so far
+// @Tags Auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id}/revoke-tokens [post]
+func (h *Handler) RevokeUserTokens(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.RevokeUserTokens(c.Request().Context(), id)
+	if errors.Is(err, service.ErrUserNotFound) {
+		return JSON(c, http.StatusNotFound, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "revoked"})
+}
+
+// UnlockUser lifts a login lockout
+// @Summary Unlock user
+// @Description Clear the failed-login counter and lockout of a user's account
+// @Tags Auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id}/unlock [post]
+func (h *Handler) UnlockUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.UnlockUser(c.Request().Context(), id)
+	if errors.Is(err, service.ErrUserNotFound) {
+		return JSON(c, http.StatusNotFound, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "unlocked"})
+}
+
+// LinkUserStudent links a user account to a student record
+// @Summary Link user to student
+// @Description Link a user account to a student record so the user can see their own data under /me. Send a null student_id to unlink.
+// @Tags Auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param input body handlers.LinkStudentInput true "Student ID"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id}/student [put]
+func (h *Handler) LinkUserStudent(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	var input LinkStudentInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.LinkStudent(c.Request().Context(), id, input.StudentID)
+	switch {
+	case errors.Is(err, service.ErrUserNotFound), errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case errors.Is(err, service.ErrStudentAlreadyLinked):
+		return JSON(c, http.StatusConflict, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "updated"})
 }
 
 // GetMe gets the current user's profile
 // @Summary Get Current User
-// @Description Get the profile details of the currently logged-in user
+// @Description Get the profile details of the currently logged-in user, including the linked student record if there is one
 // @Tags Auth
 // @Security BearerAuth
 // @Accept json
@@ -92,8 +467,29 @@ func (h *Handler) GetMe(c echo.Context) error {
 		return JSON(c, http.StatusNotFound, err)
 	}
 
+	// A link to a student who has since been archived counts as none.
+	student, err := h.service.GetLinkedStudent(c.Request().Context(), id)
+	if err != nil && !errors.Is(err, service.ErrNoLinkedStudent) {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"id":    user.ID,
-		"email": user.Email,
+		"id":                 user.ID,
+		"email":              user.Email,
+		"role":               user.Role,
+		"student":            student,
+		"two_factor_enabled": user.TwoFactorEnabled,
 	})
 }
+
+// JWKS publishes the keys access tokens are verified with
+// @Summary JSON Web Key Set
+// @Description Public keys for verifying access tokens, selected by the kid header. Shared HS256 secrets are not published
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} jwtkeys.JWKSet
+// @Router /.well-known/jwks.json [get]
+func (h *Handler) JWKS(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(http.StatusOK, h.service.JWKS())
+}
@@ -3,16 +3,19 @@ package handlers
 import (
 	"errors"
 	"net/http"
-	"os"
+	"slices"
 	"strings"
 
 	"github.com/ansarctica/domashka4/internal/service"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
 func (h *Handler) UserIdentity(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if key := c.Request().Header.Get("X-API-Key"); key != "" {
+			return h.apiKeyIdentity(c, next, key)
+		}
+
 		header := c.Request().Header.Get("Authorization")
 		if header == "" {
 			return JSON(c, http.StatusUnauthorized, errors.New("no auth header"))
@@ -23,26 +26,71 @@ func (h *Handler) UserIdentity(next echo.HandlerFunc) echo.HandlerFunc {
 			return JSON(c, http.StatusUnauthorized, errors.New("wrong auth header format"))
 		}
 
-		tokenString := headerParts[1]
-
-		token, err := jwt.ParseWithClaims(tokenString, &service.TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-			_, ok := token.Method.(*jwt.SigningMethodHMAC)
-			if !ok {
-				return nil, echo.NewHTTPError(http.StatusUnauthorized, "wrong signing method")
-			}
-			return []byte(os.Getenv("SECRET_KEY")), nil
-		})
-
+		claims, err := h.service.ParseToken(headerParts[1])
 		if err != nil {
 			return JSON(c, http.StatusUnauthorized, err)
 		}
 
-		claims, ok := token.Claims.(*service.TokenClaims)
-		if ok && token.Valid {
-			c.Set("userId", claims.UserID)
-			return next(c)
+		if err := h.service.CheckToken(c.Request().Context(), claims); err != nil {
+			return JSON(c, http.StatusUnauthorized, err)
 		}
 
-		return JSON(c, http.StatusUnauthorized, errors.New("token is not valid"))
+		c.Set("claims", claims)
+		c.Set("userId", claims.UserID)
+		c.Set("role", claims.Role)
+		return next(c)
+	}
+}
+
+// apiKeyIdentity authenticates a machine client by API key. A key only opens
+// the route groups in its scopes, where the group is the first segment of the
+// matched route path; account and auth routes are never reachable by key.
+func (h *Handler) apiKeyIdentity(c echo.Context, next echo.HandlerFunc, key string) error {
+	apiKey, err := h.service.AuthenticateAPIKey(c.Request().Context(), key)
+	if errors.Is(err, service.ErrInvalidAPIKey) {
+		return JSON(c, http.StatusUnauthorized, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	group, _, _ := strings.Cut(strings.TrimPrefix(c.Path(), "/"), "/")
+	if !slices.Contains(apiKey.Scopes, group) {
+		return JSON(c, http.StatusForbidden, errors.New("api key is not allowed to access this route"))
+	}
+
+	c.Set("userId", apiKey.UserID)
+	c.Set("role", apiKey.Role)
+	c.Set("apiKeyId", apiKey.ID)
+	return next(c)
+}
+
+// RequireTwoFactor turns away users whose role requires two-factor
+// authentication until they have it, leaving them only the routes outside
+// this middleware to enroll with. API keys are let through, as only their
+// owner's login could create them.
+func (h *Handler) RequireTwoFactor(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, ok := c.Get("claims").(*service.TokenClaims)
+		if ok && !claims.TwoFactor && h.service.TwoFactorRequired(claims.Role) {
+			return JSON(c, http.StatusForbidden, service.ErrTwoFactorRequired)
+		}
+		return next(c)
+	}
+}
+
+// RequireRole lets the request through only when the role set by
+// UserIdentity is one of roles, and answers 403 otherwise.
+func (h *Handler) RequireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			role, _ := c.Get("role").(string)
+			for _, allowed := range roles {
+				if role == allowed {
+					return next(c)
+				}
+			}
+			return JSON(c, http.StatusForbidden, errors.New("insufficient role"))
+		}
 	}
 }
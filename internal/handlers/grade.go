@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/service"
 	"github.com/labstack/echo/v4"
 )
 
@@ -23,26 +25,46 @@ type GradeInput struct {
 
 // GetAssignments retrieves assignments
 // @Summary Get assignments
-// @Description Get a list of assignments, optionally filtered by subject
+// @Description Get a list of assignments, optionally filtered by subject. Teachers who leave out the subject only get the subjects they teach.
 // @Tags Assignments
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param subject_name query string false "Filter by Subject Name"
-// @Success 200 {array} models.Assignment
+// @Param sort query string false "Comma separated columns to sort by, - in front for descending: id, name, subject_name, weight, date"
+// @Param cursor query string false "next_cursor or prev_cursor of another page"
+// @Param limit query int false "Page size (default 20, at most 100)"
+// @Success 200 {object} models.Page[models.Assignment]
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /assignments [get]
 func (h *Handler) GetAssignments(c echo.Context) error {
 	var params struct {
 		SubjectName *string `query:"subject_name"`
+		Sort        string  `query:"sort"`
+		Cursor      string  `query:"cursor"`
+		Limit       int     `query:"limit"`
 	}
 
 	if err := c.Bind(&params); err != nil {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	assignments, err := h.service.GetAssignments(c.Request().Context(), params.SubjectName)
+	filter := models.AssignmentFilter{
+		SubjectName: params.SubjectName,
+		Sort:        params.Sort,
+		Page:        models.PageRequest{Cursor: params.Cursor, Limit: params.Limit},
+	}
+
+	assignments, err := h.service.GetAssignments(c.Request().Context(), identity(c), filter)
+	if errors.Is(err, service.ErrInvalidCursor) || errors.Is(err, service.ErrInvalidSort) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	if errors.Is(err, service.ErrNoTeacherProfile) {
+		return JSON(c, http.StatusForbidden, err)
+	}
 	if err != nil {
 		return JSON(c, http.StatusInternalServerError, err)
 	}
@@ -55,11 +77,13 @@ func (h *Handler) GetAssignments(c echo.Context) error {
 // @Description Add a new assignment for a subject
 // @Tags Assignments
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param input body handlers.AssignmentInput true "Assignment Data"
 // @Success 201 {object} map[string]int "Returns created ID"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /assignments [post]
 func (h *Handler) CreateAssignment(c echo.Context) error {
@@ -81,8 +105,11 @@ func (h *Handler) CreateAssignment(c echo.Context) error {
 		Date:        parsedDate,
 	}
 
-	id, err := h.service.NewAssignment(c.Request().Context(), assignment)
-	if err != nil {
+	id, err := h.service.NewAssignment(c.Request().Context(), identity(c), assignment)
+	switch {
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, service.ErrNoTeacherProfile):
+		return JSON(c, http.StatusForbidden, err)
+	case err != nil:
 		return JSON(c, http.StatusInternalServerError, err)
 	}
 
@@ -91,14 +118,17 @@ func (h *Handler) CreateAssignment(c echo.Context) error {
 
 // CreateGrade records a grade for a student
 // @Summary Create grade
-// @Description Record a grade for a specific assignment
+// @Description Record a grade for a specific assignment. Only enrolled students can be graded.
 // @Tags Grades
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param input body handlers.GradeInput true "Grade Data"
 // @Success 201 {object} map[string]int "Returns created ID"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse "The student is not enrolled"
 // @Failure 500 {object} models.ErrorResponse
 // @Router /grades [post]
 func (h *Handler) CreateGrade(c echo.Context) error {
@@ -114,8 +144,15 @@ func (h *Handler) CreateGrade(c echo.Context) error {
 		Mark:         input.Mark,
 	}
 
-	id, err := h.service.NewGrade(c.Request().Context(), grade)
-	if err != nil {
+	id, err := h.service.NewGrade(c.Request().Context(), identity(c), grade)
+	switch {
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, service.ErrNoTeacherProfile):
+		return JSON(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrAssignmentNotFound), errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrStudentInactive):
+		return JSON(c, http.StatusConflict, err)
+	case err != nil:
 		return JSON(c, http.StatusInternalServerError, err)
 	}
 
@@ -124,28 +161,54 @@ func (h *Handler) CreateGrade(c echo.Context) error {
 
 // GetRankings retrieves student rankings
 // @Summary Get rankings
-// @Description Get student rankings by GPA, filtered by group or subject
+// @Description Get student rankings by GPA, filtered by group or subject. Only enrolled students are ranked unless include_inactive is set.
 // @Tags Grades
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param group_id query int false "Filter by Group ID"
 // @Param subject_name query string false "Filter by Subject Name"
-// @Success 200 {array} models.StudentGPA
+// @Param as_of query string false "Date (DD.MM.YYYY) to rank the group as it was then, by the assignments up to then"
+// @Param include_inactive query bool false "Rank students who are not enrolled too"
+// @Param cursor query string false "next_cursor or prev_cursor of another page"
+// @Param limit query int false "Page size (default 20, at most 100)"
+// @Success 200 {object} models.Page[models.StudentGPA]
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /rankings [get]
 func (h *Handler) GetRankings(c echo.Context) error {
 	var params struct {
-		GroupID     *int    `query:"group_id"`
-		SubjectName *string `query:"subject_name"`
+		GroupID         *int    `query:"group_id"`
+		SubjectName     *string `query:"subject_name"`
+		AsOf            string  `query:"as_of"`
+		IncludeInactive bool    `query:"include_inactive"`
+		Cursor          string  `query:"cursor"`
+		Limit           int     `query:"limit"`
 	}
 
 	if err := c.Bind(&params); err != nil {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	rankings, err := h.service.GetRankings(c.Request().Context(), params.GroupID, params.SubjectName)
+	asOf, err := parseDate(params.AsOf)
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	filter := models.RankingFilter{
+		GroupID:         params.GroupID,
+		SubjectName:     params.SubjectName,
+		AsOf:            asOf,
+		IncludeInactive: params.IncludeInactive,
+		Page:            models.PageRequest{Cursor: params.Cursor, Limit: params.Limit},
+	}
+
+	rankings, err := h.service.GetRankings(c.Request().Context(), filter)
+	if errors.Is(err, service.ErrInvalidCursor) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
 	if err != nil {
 		return JSON(c, http.StatusInternalServerError, err)
 	}
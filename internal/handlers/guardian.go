@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+
+	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type GuardianInvitationInput struct {
+	Email      string `json:"email"`
+	StudentIDs []int  `json:"student_ids"`
+}
+
+type AcceptInvitationInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// InviteGuardian invites a parent or guardian
+// @Summary Invite guardian
+// @Description Email a one-time link that gives a parent or guardian read-only access to the listed students
+// @Tags Guardians
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param input body handlers.GuardianInvitationInput true "Guardian Email and Students"
+// @Success 201 {object} models.GuardianInvitation
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /guardians/invitations [post]
+func (h *Handler) InviteGuardian(c echo.Context) error {
+	var input GuardianInvitationInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	if _, err := mail.ParseAddress(input.Email); err != nil {
+		return JSON(c, http.StatusBadRequest, errors.New("wrong email format"))
+	}
+
+	inv, err := h.service.InviteGuardian(c.Request().Context(), identity(c), input.Email, input.StudentIDs)
+	if errors.Is(err, service.ErrInvitationRecipient) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusCreated, inv)
+}
+
+// AcceptGuardianInvitation accepts a guardian invitation
+// @Summary Accept guardian invitation
+// @Description Accept a guardian invitation with the token from the email. If the invited email has no account yet, one is created with the given password.
+// @Tags Guardians
+// @Accept json
+// @Produce json
+// @Param input body handlers.AcceptInvitationInput true "Invitation Token and Password"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /auth/guardian/accept [post]
+func (h *Handler) AcceptGuardianInvitation(c echo.Context) error {
+	var input AcceptInvitationInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err := h.service.AcceptGuardianInvitation(c.Request().Context(), input.Token, input.Password)
+	switch {
+	case errors.Is(err, service.ErrInvalidInvitation), errors.Is(err, service.ErrPasswordRequired),
+		errors.Is(err, service.ErrWeakPassword):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrNotGuardianAccount):
+		return JSON(c, http.StatusConflict, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "accepted"})
+}
+
+// GetGuardianStudents lists the caller's students
+// @Summary Get my students as guardian
+// @Description Get the students the current guardian has access to
+// @Tags Guardians
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Success 200 {array} models.Student
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /guardians/me/students [get]
+func (h *Handler) GetGuardianStudents(c echo.Context) error {
+	students, err := h.service.GetGuardianStudents(c.Request().Context(), identity(c).UserID)
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, students)
+}
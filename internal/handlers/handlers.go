@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/ansarctica/domashka4/internal/models"
 	"github.com/ansarctica/domashka4/internal/service"
 	"github.com/labstack/echo/v4"
@@ -20,3 +22,23 @@ func JSON(c echo.Context, status int, err error) error {
 	}
 	return c.JSON(status, response)
 }
+
+// identity returns the caller that UserIdentity authenticated.
+func identity(c echo.Context) models.Identity {
+	userID, _ := c.Get("userId").(int)
+	role, _ := c.Get("role").(string)
+	return models.Identity{UserID: userID, Role: role}
+}
+
+// parseDate parses an optional date in DD.MM.YYYY format, the format dates
+// are given in throughout the API.
+func parseDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("02.01.2006", s)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) linkedStudent(c echo.Context) (*models.Student, error) {
+	userID, ok := c.Get("userId").(int)
+	if !ok {
+		return nil, errors.New("no user id")
+	}
+	return h.service.GetLinkedStudent(c.Request().Context(), userID)
+}
+
+func linkedStudentError(c echo.Context, err error) error {
+	if errors.Is(err, service.ErrNoLinkedStudent) {
+		return JSON(c, http.StatusNotFound, err)
+	}
+	return JSON(c, http.StatusInternalServerError, err)
+}
+
+// GetMyGrades retrieves the caller's grades
+// @Summary Get my grades
+// @Description Get the grades of the student linked to the current user
+// @Tags Me
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Success 200 {array} models.StudentGrade
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /me/grades [get]
+func (h *Handler) GetMyGrades(c echo.Context) error {
+	student, err := h.linkedStudent(c)
+	if err != nil {
+		return linkedStudentError(c, err)
+	}
+
+	grades, err := h.service.GetGrades(c.Request().Context(), student.ID)
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, grades)
+}
+
+// GetMyAttendance retrieves the caller's attendance
+// @Summary Get my attendance
+// @Description Get the attendance records of the student linked to the current user
+// @Tags Me
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param cursor query string false "next_cursor or prev_cursor of another page"
+// @Param limit query int false "Page size (default 20, at most 100)"
+// @Success 200 {object} models.Page[models.Attendance]
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /me/attendance [get]
+func (h *Handler) GetMyAttendance(c echo.Context) error {
+	var params struct {
+		Cursor string `query:"cursor"`
+		Limit  int    `query:"limit"`
+	}
+
+	if err := c.Bind(&params); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	student, err := h.linkedStudent(c)
+	if err != nil {
+		return linkedStudentError(c, err)
+	}
+
+	filter := models.AttendanceFilter{
+		StudentID:       &student.ID,
+		IncludeInactive: true,
+		Page:            models.PageRequest{Cursor: params.Cursor, Limit: params.Limit},
+	}
+
+	attendanceList, err := h.service.GetAttendance(c.Request().Context(), identity(c), filter)
+	if errors.Is(err, service.ErrInvalidCursor) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, attendanceList)
+}
+
+// GetMySchedule retrieves the caller's schedule
+// @Summary Get my schedule
+// @Description Get the schedule of the group of the student linked to the current user
+// @Tags Me
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Success 200 {array} map[string]interface{}
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /me/schedule [get]
+func (h *Handler) GetMySchedule(c echo.Context) error {
+	student, err := h.linkedStudent(c)
+	if err != nil {
+		return linkedStudentError(c, err)
+	}
+
+	schedules, err := h.service.GetGroupSchedule(c.Request().Context(), student.GroupID)
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, formatSchedules(schedules))
+}
+
+// GetMyGPA calculates the caller's GPA
+// @Summary Get my GPA
+// @Description Calculate the GPA of the student linked to the current user
+// @Tags Me
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /me/gpa [get]
+func (h *Handler) GetMyGPA(c echo.Context) error {
+	student, err := h.linkedStudent(c)
+	if err != nil {
+		return linkedStudentError(c, err)
+	}
+
+	gpa, err := h.service.GetGPA(c.Request().Context(), identity(c), student.ID)
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"student_id": student.ID,
+		"gpa":        gpa,
+	})
+}
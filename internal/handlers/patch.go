@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	mimeMergePatch = "application/merge-patch+json"
+	mimeJSONPatch  = "application/json-patch+json"
+)
+
+var (
+	errInvalidPatch     = errors.New("invalid patch")
+	errPatchTestFailed  = errors.New("patch test operation failed")
+	errUnsupportedPatch = fmt.Errorf("patch must be sent as %s, %s or %s", mimeMergePatch, mimeJSONPatch, echo.MIMEApplicationJSON)
+)
+
+// readPatch reads the request body as a patch to a document of type T and
+// returns a function that applies it. The body is a JSON merge patch
+// (RFC 7396), which sets the fields it names and leaves the rest alone, or,
+// sent as application/json-patch+json, a JSON Patch (RFC 6902). Fields the
+// patch removes are left at their zero value, and the patched document must
+// not have fields T does not know.
+func readPatch[T any](c echo.Context) (func(*T) error, error) {
+	mediaType, _, err := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if err != nil {
+		return nil, errUnsupportedPatch
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var apply func(doc []byte) ([]byte, error)
+	switch mediaType {
+	case mimeMergePatch, echo.MIMEApplicationJSON:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return nil, fmt.Errorf("%w: a merge patch must be a JSON object", errInvalidPatch)
+		}
+		apply = func(doc []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(doc, body)
+		}
+	case mimeJSONPatch:
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidPatch, err)
+		}
+		apply = patch.Apply
+	default:
+		return nil, errUnsupportedPatch
+	}
+
+	return func(v *T) error {
+		doc, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		patched, err := apply(doc)
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return errPatchTestFailed
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidPatch, err)
+		}
+
+		var result T
+		decoder := json.NewDecoder(bytes.NewReader(patched))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&result); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidPatch, err)
+		}
+		*v = result
+		return nil
+	}, nil
+}
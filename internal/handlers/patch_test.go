@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+type patchDoc struct {
+	Name  string   `json:"name"`
+	Year  int      `json:"year"`
+	Major *string  `json:"major"`
+	Tags  []string `json:"tags"`
+}
+
+func TestReadPatch(t *testing.T) {
+	major := "Physics"
+	original := func() patchDoc {
+		m := major
+		return patchDoc{Name: "Ann", Year: 2, Major: &m, Tags: []string{"a"}}
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		// readErr is what reading the patch fails with, applyErr what
+		// applying it does.
+		readErr  error
+		applyErr error
+		want     patchDoc
+	}{
+		{
+			name:        "merge patch sets named fields only",
+			contentType: mimeMergePatch,
+			body:        `{"year": 3}`,
+			want:        patchDoc{Name: "Ann", Year: 3, Major: &major, Tags: []string{"a"}},
+		},
+		{
+			name:        "plain JSON is a merge patch",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name": "Bob"}`,
+			want:        patchDoc{Name: "Bob", Year: 2, Major: &major, Tags: []string{"a"}},
+		},
+		{
+			name:        "merge patch null removes a field",
+			contentType: mimeMergePatch,
+			body:        `{"major": null}`,
+			want:        patchDoc{Name: "Ann", Year: 2, Tags: []string{"a"}},
+		},
+		{
+			name:        "merge patch replaces arrays",
+			contentType: mimeMergePatch,
+			body:        `{"tags": ["b", "c"]}`,
+			want:        patchDoc{Name: "Ann", Year: 2, Major: &major, Tags: []string{"b", "c"}},
+		},
+		{
+			name:        "merge patch must be an object",
+			contentType: mimeMergePatch,
+			body:        `[{"op": "remove", "path": "/major"}]`,
+			readErr:     errInvalidPatch,
+		},
+		{
+			name:        "merge patch with unknown field",
+			contentType: mimeMergePatch,
+			body:        `{"nmae": "Bob"}`,
+			applyErr:    errInvalidPatch,
+		},
+		{
+			name:        "merge patch with wrong type",
+			contentType: mimeMergePatch,
+			body:        `{"year": "three"}`,
+			applyErr:    errInvalidPatch,
+		},
+		{
+			name:        "JSON patch operations",
+			contentType: mimeJSONPatch,
+			body:        `[{"op": "replace", "path": "/year", "value": 4}, {"op": "add", "path": "/tags/-", "value": "z"}, {"op": "remove", "path": "/major"}]`,
+			want:        patchDoc{Name: "Ann", Year: 4, Tags: []string{"a", "z"}},
+		},
+		{
+			name:        "JSON patch test that holds",
+			contentType: mimeJSONPatch,
+			body:        `[{"op": "test", "path": "/name", "value": "Ann"}, {"op": "replace", "path": "/name", "value": "Bob"}]`,
+			want:        patchDoc{Name: "Bob", Year: 2, Major: &major, Tags: []string{"a"}},
+		},
+		{
+			name:        "JSON patch test that fails",
+			contentType: mimeJSONPatch,
+			body:        `[{"op": "test", "path": "/name", "value": "Eve"}, {"op": "replace", "path": "/name", "value": "Bob"}]`,
+			applyErr:    errPatchTestFailed,
+		},
+		{
+			name:        "JSON patch on a missing path",
+			contentType: mimeJSONPatch,
+			body:        `[{"op": "replace", "path": "/nope", "value": 1}]`,
+			applyErr:    errInvalidPatch,
+		},
+		{
+			name:        "JSON patch adding an unknown field",
+			contentType: mimeJSONPatch,
+			body:        `[{"op": "add", "path": "/nope", "value": 1}]`,
+			applyErr:    errInvalidPatch,
+		},
+		{
+			name:        "JSON patch that is not a list",
+			contentType: mimeJSONPatch,
+			body:        `{"year": 3}`,
+			readErr:     errInvalidPatch,
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "text/plain",
+			body:        `{"year": 3}`,
+			readErr:     errUnsupportedPatch,
+		},
+		{
+			name:    "no content type",
+			body:    `{"year": 3}`,
+			readErr: errUnsupportedPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set(echo.HeaderContentType, tt.contentType)
+			}
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			apply, err := readPatch[patchDoc](c)
+			if tt.readErr != nil {
+				if !errors.Is(err, tt.readErr) {
+					t.Errorf("readPatch() error = %v, want %v", err, tt.readErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readPatch() error = %v", err)
+			}
+
+			doc := original()
+			err = apply(&doc)
+			if tt.applyErr != nil {
+				if !errors.Is(err, tt.applyErr) {
+					t.Errorf("apply() error = %v, want %v", err, tt.applyErr)
+				}
+				if doc.Name != "Ann" || doc.Year != 2 {
+					t.Errorf("failed apply() changed the document to %+v", doc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("apply() error = %v", err)
+			}
+			if !reflect.DeepEqual(doc, tt.want) {
+				t.Errorf("apply() = %+v, want %+v", doc, tt.want)
+			}
+		})
+	}
+}
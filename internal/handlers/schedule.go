@@ -1,40 +1,64 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/service"
 	"github.com/labstack/echo/v4"
 )
 
 // GetSchedules retrieves class schedules
 // @Summary Get schedules
-// @Description Get schedules, optionally filtered by group ID
+// @Description Get schedules, optionally filtered by group ID. Teachers who leave out the group only get their own classes.
 // @Tags Schedules
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param group_id query int false "Filter by Group ID"
-// @Success 200 {array} map[string]interface{}
+// @Param cursor query string false "next_cursor or prev_cursor of another page"
+// @Param limit query int false "Page size (default 20, at most 100)"
+// @Success 200 {object} models.Page[models.Schedule]{items=[]map[string]interface{}}
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /schedules [get]
 func (h *Handler) GetSchedules(c echo.Context) error {
 	var params struct {
-		GroupID *int `query:"group_id"`
+		GroupID *int   `query:"group_id"`
+		Cursor  string `query:"cursor"`
+		Limit   int    `query:"limit"`
 	}
 
 	if err := c.Bind(&params); err != nil {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	schedules, err := h.service.GetSchedules(c.Request().Context(), params.GroupID)
+	filter := models.ScheduleFilter{
+		GroupID: params.GroupID,
+		Page:    models.PageRequest{Cursor: params.Cursor, Limit: params.Limit},
+	}
+
+	schedules, err := h.service.GetSchedules(c.Request().Context(), identity(c), filter)
+	if errors.Is(err, service.ErrInvalidCursor) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	if errors.Is(err, service.ErrNoTeacherProfile) {
+		return JSON(c, http.StatusForbidden, err)
+	}
 	if err != nil {
 		return JSON(c, http.StatusInternalServerError, err)
 	}
 
-	return c.JSON(http.StatusOK, formatSchedules(schedules))
+	return c.JSON(http.StatusOK, models.Page[map[string]interface{}]{
+		Items:      formatSchedules(schedules.Items),
+		Total:      schedules.Total,
+		NextCursor: schedules.NextCursor,
+		PrevCursor: schedules.PrevCursor,
+	})
 }
 
 // CreateSchedule adds a new schedule entry
@@ -42,11 +66,13 @@ func (h *Handler) GetSchedules(c echo.Context) error {
 // @Description Add a new class schedule entry
 // @Tags Schedules
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param input body models.Schedule true "Schedule Data"
 // @Success 201 {object} map[string]int "Returns created ID"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /schedules [post]
 func (h *Handler) CreateSchedule(c echo.Context) error {
@@ -66,15 +92,20 @@ func (h *Handler) CreateSchedule(c echo.Context) error {
 
 // UpdateSchedule modifies a schedule entry
 // @Summary Update schedule
-// @Description Update an existing schedule entry
+// @Description Update the given fields of a schedule entry with a JSON merge patch (RFC 7396), or a JSON Patch (RFC 6902) sent as application/json-patch+json. Fields left out keep their values, and the result must still be a valid entry.
 // @Tags Schedules
 // @Security BearerAuth
-// @Accept json
+// @Security ApiKeyAuth
+// @Accept json,application/merge-patch+json,application/json-patch+json
 // @Produce json
 // @Param id path int true "Schedule ID"
-// @Param input body models.Schedule true "Updated Schedule Data"
+// @Param input body models.Schedule true "Fields to change"
 // @Success 200 {object} map[string]string "Returns status"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse "A JSON Patch test operation failed"
+// @Failure 415 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /schedules/{id} [patch]
 func (h *Handler) UpdateSchedule(c echo.Context) error {
@@ -83,13 +114,20 @@ func (h *Handler) UpdateSchedule(c echo.Context) error {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	var schedule models.Schedule
-	if err := c.Bind(&schedule); err != nil {
-		return JSON(c, http.StatusBadRequest, err)
+	patch, err := readPatch[models.Schedule](c)
+	if err == nil {
+		err = h.service.UpdateSchedule(c.Request().Context(), id, patch)
 	}
-	schedule.ID = id
-
-	if err := h.service.UpdateSchedule(c.Request().Context(), &schedule); err != nil {
+	switch {
+	case errors.Is(err, errUnsupportedPatch):
+		return JSON(c, http.StatusUnsupportedMediaType, err)
+	case errors.Is(err, errInvalidPatch), errors.Is(err, service.ErrInvalidSchedule), errors.Is(err, service.ErrInvalidReference):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, errPatchTestFailed):
+		return JSON(c, http.StatusConflict, err)
+	case errors.Is(err, service.ErrScheduleNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case err != nil:
 		return JSON(c, http.StatusInternalServerError, err)
 	}
 	return c.JSON(http.StatusOK, map[string]string{"status": "updated"})
@@ -100,11 +138,13 @@ func (h *Handler) UpdateSchedule(c echo.Context) error {
 // @Description Remove a schedule entry from the database
 // @Tags Schedules
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param id path int true "Schedule ID"
 // @Success 200 {object} map[string]string "Returns status"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /schedules/{id} [delete]
 func (h *Handler) DeleteSchedule(c echo.Context) error {
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+// GetMySessions lists the current user's sessions
+// @Summary List my sessions
+// @Description List the devices and browsers the current user is logged in on. The session of this request is marked current
+// @Tags Sessions
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {array} models.Session
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/sessions [get]
+func (h *Handler) GetMySessions(c echo.Context) error {
+	var current int
+	if claims, ok := c.Get("claims").(*service.TokenClaims); ok {
+		current = claims.SessionID
+	}
+
+	sessions, err := h.service.GetSessions(c.Request().Context(), identity(c).UserID, current)
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// TerminateMySession signs one of the current user's sessions out
+// @Summary Terminate my session
+// @Description Sign out a session of the current user. Its refresh token stops working and so do its access tokens
+// @Tags Sessions
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "Session ID"
+// @Success 204
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/sessions/{id} [delete]
+func (h *Handler) TerminateMySession(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	return h.terminateSession(c, identity(c).UserID, id)
+}
+
+// GetUserSessions lists a user's sessions
+// @Summary List user sessions
+// @Description List where a user is logged in
+// @Tags Sessions
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.Session
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id}/sessions [get]
+func (h *Handler) GetUserSessions(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	if _, err := h.service.GetUserByID(c.Request().Context(), id); err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			return JSON(c, http.StatusNotFound, err)
+		}
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	sessions, err := h.service.GetSessions(c.Request().Context(), id, 0)
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// TerminateUserSession signs one of a user's sessions out
+// @Summary Terminate user session
+// @Description Sign out a session of any user
+// @Tags Sessions
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Param sessionId path int true "Session ID"
+// @Success 204
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id}/sessions/{sessionId} [delete]
+func (h *Handler) TerminateUserSession(c echo.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	sessionID, err := strconv.Atoi(c.Param("sessionId"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	return h.terminateSession(c, userID, sessionID)
+}
+
+func (h *Handler) terminateSession(c echo.Context, userID, sessionID int) error {
+	err := h.service.TerminateSession(c.Request().Context(), userID, sessionID)
+	if errors.Is(err, service.ErrSessionNotFound) {
+		return JSON(c, http.StatusNotFound, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
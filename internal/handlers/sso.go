@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+// OIDCLogin starts a single sign-on login
+// @Summary Start single sign-on
+// @Description Redirect to the identity provider to sign in with the authorization code flow and PKCE
+// @Tags Auth
+// @Success 302
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /auth/oidc/login [get]
+func (h *Handler) OIDCLogin(c echo.Context) error {
+	url, err := h.service.StartOIDCLogin(c.Request().Context())
+	if errors.Is(err, service.ErrSSODisabled) {
+		return JSON(c, http.StatusNotFound, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.Redirect(http.StatusFound, url)
+}
+
+// OIDCCallback finishes a single sign-on login
+// @Summary Finish single sign-on
+// @Description Exchange the authorization code the identity provider redirected back with for a JWT access token and a refresh token. Accounts are matched by provider subject, then by verified email, and created when neither matches
+// @Tags Auth
+// @Produce json
+// @Param state query string true "State from the login redirect"
+// @Param code query string true "Authorization code"
+// @Success 200 {object} models.AuthTokens
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /auth/oidc/callback [get]
+func (h *Handler) OIDCCallback(c echo.Context) error {
+	if reason := c.QueryParam("error"); reason != "" {
+		if description := c.QueryParam("error_description"); description != "" {
+			reason += ": " + description
+		}
+		return JSON(c, http.StatusUnauthorized, errors.New(reason))
+	}
+
+	state, code := c.QueryParam("state"), c.QueryParam("code")
+	if state == "" || code == "" {
+		return JSON(c, http.StatusBadRequest, errors.New("state and code are required"))
+	}
+
+	tokens, err := h.service.FinishOIDCLogin(c.Request().Context(), state, code, c.RealIP(), c.Request().UserAgent())
+	switch {
+	case errors.Is(err, service.ErrSSODisabled):
+		return JSON(c, http.StatusNotFound, err)
+	case errors.Is(err, service.ErrInvalidSSOState):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrSSOFailed):
+		return JSON(c, http.StatusUnauthorized, err)
+	case errors.Is(err, service.ErrSSOEmailUnverified), errors.Is(err, service.ErrSSONoRole),
+		errors.Is(err, service.ErrAccountDisabled):
+		return JSON(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrSSOAccountConflict):
+		return JSON(c, http.StatusConflict, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
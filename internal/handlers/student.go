@@ -1,55 +1,66 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/ansarctica/domashka4/internal/sheet"
 	"github.com/labstack/echo/v4"
 )
 
 // GetStudents retrieves a list of students
 // @Summary Get all students
-// @Description Retrieve students with optional filtering by group, major, and course year
+// @Description Retrieve students with optional filtering by group, major, course year and status, and fuzzy search by name. Only enrolled students are listed unless status says otherwise. Name search results come best match first. Teachers who leave out the group only get the groups they teach.
 // @Tags Students
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
+// @Param q query string false "Search by Name, tolerating misspellings"
 // @Param group_id query int false "Filter by Group ID"
 // @Param major query string false "Filter by Major"
 // @Param course_year query int false "Filter by Course Year"
-// @Param limit query int false "Limit number of results (default 20)"
-// @Param offset query int false "Offset for pagination"
-// @Success 200 {array} models.Student
+// @Param as_of query string false "Date (DD.MM.YYYY) to list students in the groups they were in then"
+// @Param status query string false "Comma separated statuses to keep: applicant, enrolled, academic_leave, graduated, expelled, withdrawn, or all (default enrolled)"
+// @Param include_deleted query bool false "Include archived students (admins only)"
+// @Param sort query string false "Comma separated columns to sort by, - in front for descending: id, name, birth_date, gender, group_id, major, course_year, status"
+// @Param cursor query string false "next_cursor or prev_cursor of another page"
+// @Param limit query int false "Page size (default 20, at most 100)"
+// @Success 200 {object} models.Page[models.Student]
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /students [get]
 func (h *Handler) GetStudents(c echo.Context) error {
 	var params struct {
-		GroupID    *int    `query:"group_id"`
-		Major      *string `query:"major"`
-		CourseYear *int    `query:"course_year"`
-		Limit      int     `query:"limit"`
-		Offset     int     `query:"offset"`
+		List   studentListParams
+		Cursor string `query:"cursor"`
+		Limit  int    `query:"limit"`
 	}
 
 	if err := c.Bind(&params); err != nil {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	if params.Limit == 0 {
-		params.Limit = 20
+	filter, err := params.List.filter()
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
 	}
+	filter.Page = models.PageRequest{Cursor: params.Cursor, Limit: params.Limit}
 
-	filter := models.StudentFilter{
-		GroupID:    params.GroupID,
-		Major:      params.Major,
-		CourseYear: params.CourseYear,
-		Limit:      params.Limit,
-		Offset:     params.Offset,
+	students, err := h.service.GetAllStudents(c.Request().Context(), identity(c), filter)
+	if errors.Is(err, service.ErrInvalidCursor) || errors.Is(err, service.ErrInvalidSort) || errors.Is(err, service.ErrInvalidStatus) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	if errors.Is(err, service.ErrForbidden) || errors.Is(err, service.ErrNoTeacherProfile) {
+		return JSON(c, http.StatusForbidden, err)
 	}
-
-	students, err := h.service.GetAllStudents(c.Request().Context(), filter)
 	if err != nil {
 		return JSON(c, http.StatusInternalServerError, err)
 	}
@@ -57,16 +68,63 @@ func (h *Handler) GetStudents(c echo.Context) error {
 	return c.JSON(http.StatusOK, students)
 }
 
+// SuggestStudents serves name autocomplete
+// @Summary Suggest students
+// @Description Find students by a possibly misspelt name, best match first. Teachers only get students from the groups they teach.
+// @Tags Students
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json
+// @Param q query string true "Name or part of it"
+// @Param limit query int false "Limit number of results (default 10, at most 50)"
+// @Success 200 {array} models.StudentSuggestion
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /students/search/suggest [get]
+func (h *Handler) SuggestStudents(c echo.Context) error {
+	var params struct {
+		Query string `query:"q"`
+		Limit int    `query:"limit"`
+	}
+
+	if err := c.Bind(&params); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	q := strings.TrimSpace(params.Query)
+	if q == "" {
+		return JSON(c, http.StatusBadRequest, errors.New("q is required"))
+	}
+	if params.Limit <= 0 {
+		params.Limit = 10
+	}
+	params.Limit = min(params.Limit, 50)
+
+	suggestions, err := h.service.SuggestStudents(c.Request().Context(), identity(c), q, params.Limit)
+	if errors.Is(err, service.ErrNoTeacherProfile) {
+		return JSON(c, http.StatusForbidden, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, suggestions)
+}
+
 // GetStudent retrieves a specific student
 // @Summary Get a student
-// @Description Get details of a student by their ID
+// @Description Get details of a student by their ID. Guardians may only read their own students.
 // @Tags Students
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param id path int true "Student ID"
 // @Success 200 {object} models.Student
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /students/{id} [get]
 func (h *Handler) GetStudent(c echo.Context) error {
@@ -75,7 +133,13 @@ func (h *Handler) GetStudent(c echo.Context) error {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	student, err := h.service.GetStudent(c.Request().Context(), id)
+	student, err := h.service.GetStudent(c.Request().Context(), identity(c), id)
+	if errors.Is(err, service.ErrForbidden) {
+		return JSON(c, http.StatusForbidden, err)
+	}
+	if errors.Is(err, service.ErrStudentNotFound) {
+		return JSON(c, http.StatusNotFound, err)
+	}
 	if err != nil {
 		return JSON(c, http.StatusInternalServerError, err)
 	}
@@ -85,14 +149,16 @@ func (h *Handler) GetStudent(c echo.Context) error {
 
 // CreateStudent adds a new student
 // @Summary Create a student
-// @Description Add a new student record to the database
+// @Description Add a new student record to the database. The status defaults to enrolled.
 // @Tags Students
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param input body models.Student true "Student Data"
 // @Success 201 {object} map[string]int "Returns created ID"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /students [post]
 func (h *Handler) CreateStudent(c echo.Context) error {
@@ -104,6 +170,9 @@ func (h *Handler) CreateStudent(c echo.Context) error {
 	student.ID = 0
 
 	id, err := h.service.CreateStudent(c.Request().Context(), &student)
+	if errors.Is(err, service.ErrInvalidStatus) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
 	if err != nil {
 		return JSON(c, http.StatusInternalServerError, err)
 	}
@@ -113,15 +182,20 @@ func (h *Handler) CreateStudent(c echo.Context) error {
 
 // UpdateStudent updates an existing student
 // @Summary Update a student
-// @Description Update details of an existing student
+// @Description Update the given fields of a student with a JSON merge patch (RFC 7396), or a JSON Patch (RFC 6902) sent as application/json-patch+json. Fields left out keep their values, and the result must still be a valid student. Changing the group records a transfer effective today; the status is changed through POST /students/{id}/status instead.
 // @Tags Students
 // @Security BearerAuth
-// @Accept json
+// @Security ApiKeyAuth
+// @Accept json,application/merge-patch+json,application/json-patch+json
 // @Produce json
 // @Param id path int true "Student ID"
-// @Param input body models.Student true "Updated Student Data"
+// @Param input body models.Student true "Fields to change"
 // @Success 200 {object} map[string]string "Returns status"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse "A JSON Patch test operation failed"
+// @Failure 415 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /students/{id} [patch]
 func (h *Handler) UpdateStudent(c echo.Context) error {
@@ -130,29 +204,39 @@ func (h *Handler) UpdateStudent(c echo.Context) error {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	var student models.Student
-	if err := c.Bind(&student); err != nil {
-		return JSON(c, http.StatusBadRequest, err)
+	patch, err := readPatch[models.Student](c)
+	if err == nil {
+		err = h.service.UpdateStudent(c.Request().Context(), id, patch)
 	}
-	student.ID = id
-
-	if err := h.service.UpdateStudent(c.Request().Context(), &student); err != nil {
+	switch {
+	case errors.Is(err, errUnsupportedPatch):
+		return JSON(c, http.StatusUnsupportedMediaType, err)
+	case errors.Is(err, errInvalidPatch), errors.Is(err, service.ErrInvalidStudent), errors.Is(err, service.ErrInvalidReference):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, errPatchTestFailed):
+		return JSON(c, http.StatusConflict, err)
+	case errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case err != nil:
 		return JSON(c, http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"status": "updated"})
 }
 
-// DeleteStudent removes a student
+// DeleteStudent archives a student
 // @Summary Delete a student
-// @Description Remove a student record from the database
+// @Description Archive a student. Archived students are hidden everywhere but keep their grades and attendance, and can be restored or purged.
 // @Tags Students
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param id path int true "Student ID"
 // @Success 200 {object} map[string]string "Returns status"
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /students/{id} [delete]
 func (h *Handler) DeleteStudent(c echo.Context) error {
@@ -161,23 +245,269 @@ func (h *Handler) DeleteStudent(c echo.Context) error {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	if err := h.service.DeleteStudent(c.Request().Context(), id); err != nil {
+	err = h.service.DeleteStudent(c.Request().Context(), id)
+	if errors.Is(err, service.ErrStudentNotFound) {
+		return JSON(c, http.StatusNotFound, err)
+	}
+	if err != nil {
 		return JSON(c, http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
 }
 
+// RestoreStudent brings back an archived student
+// @Summary Restore a student
+// @Description Restore an archived student
+// @Tags Students
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Student ID"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse "No archived student with this ID"
+// @Failure 500 {object} models.ErrorResponse
+// @Router /students/{id}/restore [post]
+func (h *Handler) RestoreStudent(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.RestoreStudent(c.Request().Context(), id)
+	if errors.Is(err, service.ErrStudentNotFound) {
+		return JSON(c, http.StatusNotFound, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "restored"})
+}
+
+// PurgeStudent deletes an archived student for good
+// @Summary Purge a student
+// @Description Permanently delete an archived student together with their grades, attendance and guardian links. This cannot be undone.
+// @Tags Students
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Student ID"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse "The student is not archived"
+// @Failure 500 {object} models.ErrorResponse
+// @Router /students/{id}/purge [delete]
+func (h *Handler) PurgeStudent(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.PurgeStudent(c.Request().Context(), id)
+	switch {
+	case errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case errors.Is(err, service.ErrStudentNotArchived):
+		return JSON(c, http.StatusConflict, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "purged"})
+}
+
+type TransferInput struct {
+	GroupID int `json:"group_id"`
+	// EffectiveDate defaults to today.
+	EffectiveDate string `json:"effective_date"`
+}
+
+// TransferStudent moves a student to another group
+// @Summary Transfer a student
+// @Description Move a student to another group from the effective date on, closing their current group membership that day. The date defaults to today and cannot be in the future or before the student joined their current group.
+// @Tags Students
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Student ID"
+// @Param input body TransferInput true "Group and effective date (DD.MM.YYYY)"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /students/{id}/transfer [post]
+func (h *Handler) TransferStudent(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	var input TransferInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	from, err := parseDate(input.EffectiveDate)
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.TransferStudent(c.Request().Context(), id, input.GroupID, from)
+	switch {
+	case errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case errors.Is(err, service.ErrInvalidTransfer):
+		return JSON(c, http.StatusBadRequest, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "transferred"})
+}
+
+// GetStudentGroupHistory lists the groups a student has been in
+// @Summary Get student group history
+// @Description List the groups a student has been in, earliest first. valid_from is null for the group they started in and valid_to is null for their current group; a membership ends the day before valid_to. Guardians may only read their own students.
+// @Tags Students
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "Student ID"
+// @Success 200 {array} models.GroupMembership
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /students/{id}/group-history [get]
+func (h *Handler) GetStudentGroupHistory(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	history, err := h.service.GetGroupHistory(c.Request().Context(), identity(c), id)
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		return JSON(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
+type StatusChangeInput struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	// EffectiveDate defaults to today.
+	EffectiveDate string `json:"effective_date"`
+}
+
+// ChangeStudentStatus moves a student to another enrollment status
+// @Summary Change student status
+// @Description Move a student to another enrollment status with a reason. Applicants can be enrolled or withdraw; enrolled students can go on academic leave, graduate, be expelled or withdraw; students on leave can come back, be expelled or withdraw; expelled and withdrawn students can be enrolled again. Graduation is final. The date defaults to today and cannot be in the future or before the previous change.
+// @Tags Students
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Student ID"
+// @Param input body StatusChangeInput true "Status, reason and effective date (DD.MM.YYYY)"
+// @Success 200 {object} models.StatusChange
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse "The student cannot move to this status"
+// @Failure 500 {object} models.ErrorResponse
+// @Router /students/{id}/status [post]
+func (h *Handler) ChangeStudentStatus(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	var input StatusChangeInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	from, err := parseDate(input.EffectiveDate)
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	change, err := h.service.ChangeStudentStatus(c.Request().Context(), identity(c), id, input.Status, input.Reason, from)
+	switch {
+	case errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case errors.Is(err, service.ErrInvalidStatus):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrInvalidStatusChange):
+		return JSON(c, http.StatusConflict, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, change)
+}
+
+// GetStudentStatusHistory lists a student's status changes
+// @Summary Get student status history
+// @Description List the enrollment status changes of a student, earliest first. Guardians may only read their own students.
+// @Tags Students
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce json
+// @Param id path int true "Student ID"
+// @Success 200 {array} models.StatusChange
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /students/{id}/status-history [get]
+func (h *Handler) GetStudentStatusHistory(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	history, err := h.service.GetStatusHistory(c.Request().Context(), identity(c), id)
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		return JSON(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
 // GetStudentGPA calculates a student's GPA
 // @Summary Get Student GPA
-// @Description Calculate and return the GPA for a specific student
+// @Description Calculate and return the GPA for a specific student. Guardians may only read their own students.
 // @Tags Students
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Param id path int true "Student ID"
 // @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /students/{id}/gpa [get]
 func (h *Handler) GetStudentGPA(c echo.Context) error {
@@ -186,7 +516,13 @@ func (h *Handler) GetStudentGPA(c echo.Context) error {
 		return JSON(c, http.StatusBadRequest, err)
 	}
 
-	gpa, err := h.service.GetGPA(c.Request().Context(), id)
+	gpa, err := h.service.GetGPA(c.Request().Context(), identity(c), id)
+	if errors.Is(err, service.ErrForbidden) {
+		return JSON(c, http.StatusForbidden, err)
+	}
+	if errors.Is(err, service.ErrStudentNotFound) {
+		return JSON(c, http.StatusNotFound, err)
+	}
 	if err != nil {
 		return JSON(c, http.StatusInternalServerError, err)
 	}
@@ -196,3 +532,296 @@ func (h *Handler) GetStudentGPA(c echo.Context) error {
 		"gpa":        gpa,
 	})
 }
+
+// GetStudentSchedule retrieves a student's schedule
+// @Summary Get student schedule
+// @Description Get the schedule of the group a student belongs to. Guardians may only read their own students.
+// @Tags Students
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Student ID"
+// @Success 200 {array} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /students/{id}/schedule [get]
+func (h *Handler) GetStudentSchedule(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	schedules, err := h.service.GetStudentSchedule(c.Request().Context(), identity(c), id)
+	switch {
+	case errors.Is(err, service.ErrForbidden):
+		return JSON(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrStudentNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, formatSchedules(schedules))
+}
+
+const (
+	maxImportSize = 10 << 20
+	// maxImportBody leaves room for the rest of the multipart form around
+	// the file.
+	maxImportBody = maxImportSize + 1<<20
+)
+
+var errImportTooLarge = errors.New("file is larger than 10 MB")
+
+// ImportStudents creates or updates students from a spreadsheet
+// @Summary Import students
+// @Description Create or update students from a CSV or XLSX file whose first row names the columns: id, name, birth_date (YYYY-MM-DD, or a date cell in XLSX), gender, group_id, major and course_year, of which id and major are optional. Rows are matched to existing students by id or else by name and birth date, so importing a file again updates rather than duplicates. Without commit the file is only checked, and the report says what each row would do. With commit all rows are written in one transaction, or none if any row is invalid.
+// @Tags Students
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV or XLSX file, at most 10 MB"
+// @Param commit query bool false "Write the students instead of only checking the file"
+// @Success 200 {object} models.StudentImport
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 413 {object} models.ErrorResponse "File larger than 10 MB"
+// @Failure 422 {object} models.StudentImport "Invalid rows, nothing was imported"
+// @Failure 500 {object} models.ErrorResponse
+// @Router /students/import [post]
+func (h *Handler) ImportStudents(c echo.Context) error {
+	// The body is capped before anything reads it. Bind is not used here:
+	// it would parse the whole form first, and it leaves query parameters
+	// alone on a POST anyway.
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxImportBody)
+
+	var commit bool
+	if v := c.QueryParam("commit"); v != "" {
+		var err error
+		if commit, err = strconv.ParseBool(v); err != nil {
+			return JSON(c, http.StatusBadRequest, fmt.Errorf("commit %q is not true or false", v))
+		}
+	}
+
+	header, err := c.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return JSON(c, http.StatusRequestEntityTooLarge, errImportTooLarge)
+	}
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	if header.Size > maxImportSize {
+		return JSON(c, http.StatusRequestEntityTooLarge, errImportTooLarge)
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+	defer file.Close()
+
+	rows, err := sheet.Read(file, header.Filename)
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	report, err := h.service.ImportStudents(c.Request().Context(), rows, !commit)
+	switch {
+	case errors.Is(err, service.ErrImportFile):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrImportInvalid):
+		return c.JSON(http.StatusUnprocessableEntity, report)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// ExportStudents streams a roster of students
+// @Summary Export students
+// @Description Download the students GET /students would list as CSV, XLSX or JSON Lines, picked by the format parameter or else the Accept header. Rows are streamed as they are read, so any number of students can be exported.
+// @Tags Students
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Produce text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet,application/x-ndjson
+// @Param q query string false "Search by Name, tolerating misspellings"
+// @Param group_id query int false "Filter by Group ID"
+// @Param major query string false "Filter by Major"
+// @Param course_year query int false "Filter by Course Year"
+// @Param as_of query string false "Date (DD.MM.YYYY) to list students in the groups they were in then"
+// @Param status query string false "Comma separated statuses to keep: applicant, enrolled, academic_leave, graduated, expelled, withdrawn, or all (default enrolled)"
+// @Param include_deleted query bool false "Include archived students (admins only)"
+// @Param sort query string false "Comma separated columns to sort by, - in front for descending: id, name, birth_date, gender, group_id, major, course_year, status"
+// @Param format query string false "csv, xlsx or ndjson; defaults to the Accept header, then csv"
+// @Param include query string false "Comma separated computed columns to add: gpa, attendance_rate"
+// @Success 200 {file} file
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /students/export [get]
+func (h *Handler) ExportStudents(c echo.Context) error {
+	var params struct {
+		List    studentListParams
+		Format  string `query:"format"`
+		Include string `query:"include"`
+	}
+
+	if err := c.Bind(&params); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	filter, err := params.List.filter()
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	format := strings.ToLower(params.Format)
+	if format == "" {
+		format = sheet.FormatFor(c.Request().Header.Get(echo.HeaderAccept))
+	}
+	if format == "" {
+		format = sheet.FormatCSV
+	}
+	if sheet.ContentType(format) == "" {
+		return JSON(c, http.StatusBadRequest, sheet.ErrUnsupportedFormat)
+	}
+
+	columns := []string{"id", "name", "birth_date", "gender", "group_id", "major", "course_year", "status"}
+	var include models.StudentExportColumns
+	for _, name := range strings.Split(params.Include, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+		case "gpa":
+			include.GPA = true
+		case "attendance_rate":
+			include.AttendanceRate = true
+		default:
+			return JSON(c, http.StatusBadRequest, fmt.Errorf("cannot include %q, only gpa and attendance_rate", name))
+		}
+	}
+	if include.GPA {
+		columns = append(columns, "gpa")
+	}
+	if include.AttendanceRate {
+		columns = append(columns, "attendance_rate")
+	}
+
+	// The response starts with the first student, so errors that come
+	// before it can still be reported properly.
+	res := c.Response()
+	var w sheet.Writer
+	defer func() {
+		if w != nil {
+			w.Abort()
+		}
+	}()
+	start := func() error {
+		res.Header().Set(echo.HeaderContentType, sheet.ContentType(format))
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="students.%s"`, format))
+		res.WriteHeader(http.StatusOK)
+
+		var err error
+		w, err = sheet.NewWriter(res, format, columns)
+		return err
+	}
+
+	err = h.service.ExportStudents(c.Request().Context(), identity(c), filter, include, func(s *models.StudentExport) error {
+		if w == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		values := []interface{}{s.ID, s.Name, s.BirthDate.Format(time.DateOnly), s.Gender, s.GroupID, s.Major, s.CourseYear, s.Status}
+		if include.GPA {
+			values = append(values, optional(s.GPA))
+		}
+		if include.AttendanceRate {
+			values = append(values, optional(s.AttendanceRate))
+		}
+		return w.Write(values)
+	})
+	if err == nil && w == nil {
+		err = start()
+	}
+
+	switch {
+	case res.Committed && err != nil:
+		// Too late to send an error; the client gets a truncated file.
+		return err
+	case errors.Is(err, service.ErrInvalidSort), errors.Is(err, service.ErrInvalidStatus):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrForbidden), errors.Is(err, service.ErrNoTeacherProfile):
+		return JSON(c, http.StatusForbidden, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return w.Close()
+}
+
+// optional turns a nil pointer into an empty cell.
+func optional(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// studentListParams are the query parameters GET /students shares with its
+// export. Handlers bind them as a named field, which echo fills from the
+// same query.
+type studentListParams struct {
+	Query          string  `query:"q"`
+	GroupID        *int    `query:"group_id"`
+	Major          *string `query:"major"`
+	CourseYear     *int    `query:"course_year"`
+	AsOf           string  `query:"as_of"`
+	Status         string  `query:"status"`
+	IncludeDeleted bool    `query:"include_deleted"`
+	Sort           string  `query:"sort"`
+}
+
+func (p *studentListParams) filter() (models.StudentFilter, error) {
+	asOf, err := parseDate(p.AsOf)
+	if err != nil {
+		return models.StudentFilter{}, err
+	}
+
+	filter := models.StudentFilter{
+		GroupID:        p.GroupID,
+		Major:          p.Major,
+		CourseYear:     p.CourseYear,
+		AsOf:           asOf,
+		Statuses:       parseStatuses(p.Status),
+		IncludeDeleted: p.IncludeDeleted,
+		Sort:           p.Sort,
+	}
+	if q := strings.TrimSpace(p.Query); q != "" {
+		filter.Query = &q
+	}
+	return filter, nil
+}
+
+// parseStatuses reads the status parameter of the student lists: enrolled
+// students by default, all of them for "all".
+func parseStatuses(s string) []string {
+	switch s = strings.TrimSpace(s); s {
+	case "":
+		return []string{models.StatusEnrolled}
+	case "all":
+		return nil
+	}
+
+	var statuses []string
+	for _, status := range strings.Split(s, ",") {
+		statuses = append(statuses, strings.TrimSpace(status))
+	}
+	return statuses
+}
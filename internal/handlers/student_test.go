@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ansarctica/domashka4/internal/jwtkeys"
+	"github.com/ansarctica/domashka4/internal/mailer"
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/postgres"
+	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+)
+
+// newTestHandler returns a handler backed by the database at
+// TEST_DATABASE_URL, which must have schema.sql loaded. Tests using it are
+// skipped when the variable is not set.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	keys, err := jwtkeys.NewManager(context.Background(), jwtkeys.Config{Secret: "test-secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := service.NewPasswordPolicy(8, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	twoFactor, err := service.NewTwoFactorPolicy("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := service.NewLinks("http://app.test", "http://api.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := postgres.NewRepository(pool)
+	return NewHandler(service.NewService(repo, mailer.NewLogMailer(""), keys, nil, policy, twoFactor, links))
+}
+
+// importRequest posts file as the import form with the given query string.
+func importRequest(t *testing.T, h *Handler, query string, file []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "students.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(file)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/students/import"+query, &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	if err := h.ImportStudents(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestImportStudentsRejects(t *testing.T) {
+	header := []byte("name,birth_date,gender,group_id,course_year\n")
+
+	tests := []struct {
+		name       string
+		query      string
+		file       []byte
+		wantStatus int
+	}{
+		{name: "commit that is not a boolean", query: "?commit=maybe", file: header, wantStatus: http.StatusBadRequest},
+		{name: "body over the limit", file: bytes.Repeat([]byte("x"), maxImportBody+1), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "file over the limit", file: bytes.Repeat([]byte("x"), maxImportSize+1), wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every case is refused before the service is reached.
+			rec := importRequest(t, &Handler{}, tt.query, tt.file)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestImportStudentsCommit(t *testing.T) {
+	h := newTestHandler(t)
+	// A header alone imports no students, so the test leaves the database
+	// as it found it.
+	file := []byte("name,birth_date,gender,group_id,course_year\n")
+
+	tests := []struct {
+		query      string
+		wantDryRun bool
+	}{
+		{query: "", wantDryRun: true},
+		{query: "?commit=false", wantDryRun: true},
+		{query: "?commit=true", wantDryRun: false},
+		{query: "?commit=1", wantDryRun: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := importRequest(t, h, tt.query, file)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var report models.StudentImport
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.DryRun != tt.wantDryRun {
+				t.Errorf("dry_run = %v, want %v", report.DryRun, tt.wantDryRun)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 // @Description Get a list of all available subjects
 // @Tags Subjects
 // @Security BearerAuth
+// @Security ApiKeyAuth
 // @Accept json
 // @Produce json
 // @Success 200 {array} models.Subject
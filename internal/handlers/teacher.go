@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type TeacherInput struct {
+	UserID *int   `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// GetTeachers retrieves all teachers
+// @Summary Get teachers
+// @Description Get all teachers together with the subjects and groups they teach
+// @Tags Teachers
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Success 200 {array} models.Teacher
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /teachers [get]
+func (h *Handler) GetTeachers(c echo.Context) error {
+	teachers, err := h.service.GetAllTeachers(c.Request().Context())
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, teachers)
+}
+
+// GetMyTeacherProfile retrieves the caller's teaching load
+// @Summary Get my teacher profile
+// @Description Get the teacher profile of the current user and the subjects and groups they teach
+// @Tags Teachers
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.Teacher
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /teachers/me [get]
+func (h *Handler) GetMyTeacherProfile(c echo.Context) error {
+	teacher, err := h.service.GetTeacherByUserID(c.Request().Context(), identity(c).UserID)
+	if errors.Is(err, service.ErrNoTeacherProfile) {
+		return JSON(c, http.StatusNotFound, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, teacher)
+}
+
+// CreateTeacher adds a new teacher
+// @Summary Create a teacher
+// @Description Add a teacher, optionally linked to the user account they log in with
+// @Tags Teachers
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param input body handlers.TeacherInput true "Teacher Data"
+// @Success 201 {object} map[string]int "Returns created ID"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /teachers [post]
+func (h *Handler) CreateTeacher(c echo.Context) error {
+	var input TeacherInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	if input.Name == "" {
+		return JSON(c, http.StatusBadRequest, errors.New("name is required"))
+	}
+
+	id, err := h.service.CreateTeacher(c.Request().Context(), &models.Teacher{
+		UserID: input.UserID,
+		Name:   input.Name,
+	})
+	if errors.Is(err, service.ErrInvalidReference) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]int{"id": id})
+}
+
+// AddTeachingClass assigns a class to a teacher
+// @Summary Assign class to teacher
+// @Description Let a teacher teach a subject to a group
+// @Tags Teachers
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Teacher ID"
+// @Param input body models.TeachingClass true "Subject and Group"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /teachers/{id}/classes [post]
+func (h *Handler) AddTeachingClass(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	var class models.TeachingClass
+	if err := c.Bind(&class); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.AddTeachingClass(c.Request().Context(), id, class)
+	if errors.Is(err, service.ErrInvalidReference) {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "assigned"})
+}
+
+// RemoveTeachingClass takes a class away from a teacher
+// @Summary Unassign class from teacher
+// @Description Stop a teacher from teaching a subject to a group
+// @Tags Teachers
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "Teacher ID"
+// @Param subject_name query string true "Subject Name"
+// @Param group_id query int true "Group ID"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /teachers/{id}/classes [delete]
+func (h *Handler) RemoveTeachingClass(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	var params struct {
+		SubjectName string `query:"subject_name"`
+		GroupID     int    `query:"group_id"`
+	}
+	if err := c.Bind(&params); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	class := models.TeachingClass{SubjectName: params.SubjectName, GroupID: params.GroupID}
+	err = h.service.RemoveTeachingClass(c.Request().Context(), id, class)
+	if errors.Is(err, service.ErrClassNotFound) {
+		return JSON(c, http.StatusNotFound, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "unassigned"})
+}
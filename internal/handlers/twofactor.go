@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type TwoFactorCodeInput struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorLoginInput struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// EnrollTwoFactor starts TOTP enrollment
+// @Summary Start two-factor enrollment
+// @Description Generate a TOTP secret and its otpauth:// provisioning URI to show as a QR code. Two-factor authentication is only turned on once a code is confirmed
+// @Tags Two-Factor
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.TwoFactorEnrollment
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/2fa [post]
+func (h *Handler) EnrollTwoFactor(c echo.Context) error {
+	enrollment, err := h.service.EnrollTwoFactor(c.Request().Context(), identity(c).UserID)
+	if errors.Is(err, service.ErrTwoFactorEnabled) {
+		return JSON(c, http.StatusConflict, err)
+	}
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTwoFactor finishes TOTP enrollment
+// @Summary Confirm two-factor enrollment
+// @Description Turn two-factor authentication on with a code from the authenticator app. Returns recovery codes, which are not shown again. Refresh your tokens afterwards
+// @Tags Two-Factor
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param input body TwoFactorCodeInput true "TOTP Code"
+// @Success 200 {object} map[string][]string "Returns recovery codes"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/2fa/confirm [post]
+func (h *Handler) ConfirmTwoFactor(c echo.Context) error {
+	var input TwoFactorCodeInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	codes, err := h.service.ConfirmTwoFactor(c.Request().Context(), identity(c).UserID, input.Code, c.RealIP())
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns TOTP off
+// @Summary Disable two-factor authentication
+// @Description Turn two-factor authentication off with a current code or a recovery code. Not allowed for roles that require it
+// @Tags Two-Factor
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param input body TwoFactorCodeInput true "TOTP or Recovery Code"
+// @Success 204
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/2fa [delete]
+func (h *Handler) DisableTwoFactor(c echo.Context) error {
+	var input TwoFactorCodeInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err := h.service.DisableTwoFactor(c.Request().Context(), identity(c).UserID, input.Code, c.RealIP())
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes, used or not, given a current code or a recovery code
+// @Tags Two-Factor
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param input body TwoFactorCodeInput true "TOTP or Recovery Code"
+// @Success 200 {object} map[string][]string "Returns recovery codes"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/me/2fa/recovery-codes [post]
+func (h *Handler) RegenerateRecoveryCodes(c echo.Context) error {
+	var input TwoFactorCodeInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	codes, err := h.service.RegenerateRecoveryCodes(c.Request().Context(), identity(c).UserID, input.Code, c.RealIP())
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// LoginTwoFactor completes a two-step login
+// @Summary Login second step
+// @Description Exchange the challenge token from /auth/login and a TOTP or recovery code for a JWT access token and a refresh token
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param input body TwoFactorLoginInput true "Challenge and Code"
+// @Success 200 {object} models.AuthTokens
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /auth/login/2fa [post]
+func (h *Handler) LoginTwoFactor(c echo.Context) error {
+	var input TwoFactorLoginInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	tokens, err := h.service.CompleteTwoFactorLogin(c.Request().Context(), input.ChallengeToken, input.Code, c.RealIP(), c.Request().UserAgent())
+	switch {
+	case errors.Is(err, service.ErrInvalidChallenge), errors.Is(err, service.ErrInvalidTwoFactorCode):
+		return JSON(c, http.StatusUnauthorized, err)
+	case errors.Is(err, service.ErrAccountDisabled):
+		return JSON(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrTooManyAttempts):
+		return JSON(c, http.StatusTooManyRequests, err)
+	case err != nil:
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+func twoFactorError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidTwoFactorCode), errors.Is(err, service.ErrTwoFactorNotEnrolled):
+		return JSON(c, http.StatusBadRequest, err)
+	case errors.Is(err, service.ErrTwoFactorRequired):
+		return JSON(c, http.StatusForbidden, err)
+	case errors.Is(err, service.ErrTwoFactorEnabled):
+		return JSON(c, http.StatusConflict, err)
+	case errors.Is(err, service.ErrTooManyAttempts):
+		return JSON(c, http.StatusTooManyRequests, err)
+	}
+	return JSON(c, http.StatusInternalServerError, err)
+}
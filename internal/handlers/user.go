@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/service"
+	"github.com/labstack/echo/v4"
+)
+
+type UserRoleInput struct {
+	Role string `json:"role"`
+}
+
+// GetUsers lists user accounts
+// @Summary List users
+// @Description List user accounts with optional search by email and filtering by role and disabled state
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param q query string false "Part of the email"
+// @Param role query string false "Role"
+// @Param disabled query bool false "Disabled accounts only, or enabled ones only"
+// @Param limit query int false "Limit" default(20)
+// @Param offset query int false "Offset" default(0)
+// @Success 200 {object} models.UserList
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users [get]
+func (h *Handler) GetUsers(c echo.Context) error {
+	var params struct {
+		Query    *string `query:"q"`
+		Role     *string `query:"role"`
+		Disabled *bool   `query:"disabled"`
+		Limit    int     `query:"limit"`
+		Offset   int     `query:"offset"`
+	}
+
+	if err := c.Bind(&params); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 20
+	}
+
+	users, err := h.service.GetUsers(c.Request().Context(), models.UserFilter{
+		Query:    params.Query,
+		Role:     params.Role,
+		Disabled: params.Disabled,
+		Limit:    params.Limit,
+		Offset:   params.Offset,
+	})
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, users)
+}
+
+// GetUser gets a user account
+// @Summary Get user
+// @Description Get a user account by ID
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.UserAccount
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id} [get]
+func (h *Handler) GetUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	user, err := h.service.GetUserAccount(c.Request().Context(), id)
+	if err != nil {
+		return userError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// ChangeUserRole changes a user's role
+// @Summary Change user role
+// @Description Give a user another role. The user is signed out and their API keys acting with another role are revoked
+// @Tags Users
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param input body UserRoleInput true "New Role"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id}/role [put]
+func (h *Handler) ChangeUserRole(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	var input UserRoleInput
+	if err := c.Bind(&input); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.ChangeUserRole(c.Request().Context(), identity(c).UserID, id, input.Role)
+	if err != nil {
+		return userError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "role changed"})
+}
+
+// DisableUser disables a user account
+// @Summary Disable user
+// @Description Disable an account. The user is signed out everywhere, cannot log in and their API keys stop working
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id}/disable [post]
+func (h *Handler) DisableUser(c echo.Context) error {
+	return h.setUserDisabled(c, true)
+}
+
+// EnableUser re-enables a user account
+// @Summary Enable user
+// @Description Re-enable a disabled account
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id}/enable [post]
+func (h *Handler) EnableUser(c echo.Context) error {
+	return h.setUserDisabled(c, false)
+}
+
+func (h *Handler) setUserDisabled(c echo.Context, disabled bool) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.SetUserDisabled(c.Request().Context(), identity(c).UserID, id, disabled)
+	if err != nil {
+		return userError(c, err)
+	}
+
+	status := "enabled"
+	if disabled {
+		status = "disabled"
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": status})
+}
+
+// ForceUserPasswordReset makes a user choose a new password
+// @Summary Force password reset
+// @Description Remove a user's password, sign them out and email them a reset link
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string "Returns status"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id}/password-reset [post]
+func (h *Handler) ForceUserPasswordReset(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.ForcePasswordReset(c.Request().Context(), identity(c).UserID, id)
+	if err != nil {
+		return userError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "password reset"})
+}
+
+// DeleteUser deletes a user account
+// @Summary Delete user
+// @Description Delete a user account along with its tokens, sessions and API keys. Linked student and teacher records are kept
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 204
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id} [delete]
+func (h *Handler) DeleteUser(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	err = h.service.DeleteUser(c.Request().Context(), identity(c).UserID, id)
+	if err != nil {
+		return userError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetAuditLog lists admin actions on user accounts
+// @Summary Get audit log
+// @Description List admin actions on user accounts, newest first
+// @Tags Users
+// @Security BearerAuth
+// @Produce json
+// @Param user_id query int false "Only actions on this user"
+// @Param limit query int false "Limit" default(50)
+// @Param offset query int false "Offset" default(0)
+// @Success 200 {array} models.AuditEntry
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /audit-log [get]
+func (h *Handler) GetAuditLog(c echo.Context) error {
+	var params struct {
+		UserID *int `query:"user_id"`
+		Limit  int  `query:"limit"`
+		Offset int  `query:"offset"`
+	}
+
+	if err := c.Bind(&params); err != nil {
+		return JSON(c, http.StatusBadRequest, err)
+	}
+
+	if params.Limit == 0 {
+		params.Limit = 50
+	}
+
+	entries, err := h.service.GetAuditLog(c.Request().Context(), params.UserID, params.Limit, params.Offset)
+	if err != nil {
+		return JSON(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
+
+func userError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		return JSON(c, http.StatusNotFound, err)
+	case errors.Is(err, service.ErrUnknownRole), errors.Is(err, service.ErrSelfManagement):
+		return JSON(c, http.StatusBadRequest, err)
+	}
+	return JSON(c, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,457 @@
+// Package jwtkeys keeps the keys access tokens are signed and verified with.
+package jwtkeys
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+
+	rsaKeyBits = 2048
+
+	// minSyncInterval keeps tiny rotation intervals from reloading the keys
+	// in a busy loop.
+	minSyncInterval = time.Second
+)
+
+var ErrNoKey = errors.New("no JWT signing key configured: set JWT_PRIVATE_KEY_FILES or SECRET_KEY")
+
+type Config struct {
+	// KeyFiles are PEM encoded RSA or Ed25519 private keys. The first one
+	// signs new tokens, the others only verify tokens signed before a switch.
+	KeyFiles []string
+	// Secret is a shared HS256 secret, used only when there are no KeyFiles.
+	Secret string
+	// Algorithm is RS256 or EdDSA and picks the type of keys generated on
+	// rotation. Without rotation it is optional, but must name the algorithm
+	// of the configured signing key when set.
+	Algorithm string
+	// RotationInterval, when set, replaces the signing key with a freshly
+	// generated one at that interval. Generated keys are kept in the Store,
+	// so all instances sign with the same keys and publish the same JWKS,
+	// and the configured keys only verify until the grace after the first
+	// generated key took over.
+	RotationInterval time.Duration
+	// GracePeriod is how long a replaced key still verifies tokens. It should
+	// be at least the access token lifetime.
+	GracePeriod time.Duration
+}
+
+// StoredKey is a generated signing key as kept in a Store.
+type StoredKey struct {
+	ID string
+	// PrivateKey is the PKCS #8 DER encoding of the key.
+	PrivateKey []byte
+	// NotBefore is when the key starts signing tokens.
+	NotBefore time.Time
+	// RetiresAt is when the key stops verifying tokens; nil until a newer
+	// key replaces it.
+	RetiresAt *time.Time
+}
+
+// Store keeps the generated signing keys all instances share.
+type Store interface {
+	// SigningKeys returns the keys that have not retired yet.
+	SigningKeys(ctx context.Context) ([]StoredKey, error)
+	// AddSigningKey stores k and retires the keys it replaces at retireAt,
+	// unless another instance already added a key that signs after since.
+	// It reports whether k was added.
+	AddSigningKey(ctx context.Context, k StoredKey, since, retireAt time.Time) (bool, error)
+}
+
+type key struct {
+	id        string
+	alg       string
+	signing   any
+	verifying any
+	// retiresAt is when a replaced key stops verifying; zero while active.
+	retiresAt time.Time
+}
+
+type Manager struct {
+	mu     sync.RWMutex
+	cfg    Config
+	store  Store
+	active *key
+	keys   map[string]*key
+	// configured holds the keys from KeyFiles or Secret, and fallback the
+	// one of them that signs until a generated key takes over.
+	configured []*key
+	fallback   *key
+}
+
+// NewManager loads the configured keys and, with rotation on, the generated
+// keys from store, generating the first one if there is none yet. store is
+// only used with rotation and may be nil without it.
+func NewManager(ctx context.Context, cfg Config, store Store) (*Manager, error) {
+	m := &Manager{cfg: cfg, store: store, keys: make(map[string]*key)}
+
+	for i, path := range cfg.KeyFiles {
+		k, err := loadKeyFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %w", path, err)
+		}
+		m.configured = append(m.configured, k)
+		if i == 0 {
+			m.fallback = k
+		}
+	}
+
+	if m.fallback == nil && cfg.Secret != "" {
+		sum := sha256.Sum256([]byte(cfg.Secret))
+		k := &key{
+			id:        "hs-" + base64.RawURLEncoding.EncodeToString(sum[:6]),
+			alg:       AlgHS256,
+			signing:   []byte(cfg.Secret),
+			verifying: []byte(cfg.Secret),
+		}
+		m.configured = append(m.configured, k)
+		m.fallback = k
+	}
+
+	if cfg.RotationInterval <= 0 {
+		if m.fallback == nil {
+			return nil, ErrNoKey
+		}
+		if cfg.Algorithm != "" && cfg.Algorithm != m.fallback.alg {
+			return nil, fmt.Errorf("JWT_ALGORITHM is %s but the signing key is %s", cfg.Algorithm, m.fallback.alg)
+		}
+		if err := m.load(nil, time.Now()); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	if cfg.Algorithm != AlgRS256 && cfg.Algorithm != AlgEdDSA {
+		return nil, fmt.Errorf("key rotation needs JWT_ALGORITHM to be %s or %s", AlgRS256, AlgEdDSA)
+	}
+	if store == nil {
+		return nil, errors.New("key rotation needs a key store")
+	}
+	if err := m.sync(ctx); err != nil {
+		return nil, fmt.Errorf("loading generated keys: %w", err)
+	}
+
+	return m, nil
+}
+
+// Run keeps the keys in step with the store until ctx is done, rotating the
+// signing key when it is RotationInterval old. It returns at once if rotation
+// is not configured.
+func (m *Manager) Run(ctx context.Context) {
+	if m.cfg.RotationInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.syncInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.sync(ctx); err != nil {
+				log.Printf("syncing JWT signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// syncInterval is how often instances reload the stored keys: a quarter of
+// the rotation interval, but at least once a minute and at most once a
+// second.
+func (m *Manager) syncInterval() time.Duration {
+	return max(min(m.cfg.RotationInterval/4, time.Minute), minSyncInterval)
+}
+
+// sync reloads the stored keys, first adding a new one when the newest is
+// due for rotation. A new key only starts signing two sync intervals after
+// it is stored, so every instance has loaded it, and can verify tokens
+// signed with it, by then. Only the very first key signs at once, as no
+// instance can have signed with a generated key before it.
+func (m *Manager) sync(ctx context.Context) error {
+	stored, err := m.store.SigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var newest time.Time
+	for _, s := range stored {
+		if s.NotBefore.After(newest) {
+			newest = s.NotBefore
+		}
+	}
+
+	if len(stored) == 0 || !now.Before(newest.Add(m.cfg.RotationInterval)) {
+		k, err := generateKey(m.cfg.Algorithm)
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(k.signing)
+		if err != nil {
+			return err
+		}
+
+		notBefore := now
+		if len(stored) > 0 {
+			notBefore = now.Add(2 * m.syncInterval())
+		}
+		since := now.Add(-m.cfg.RotationInterval)
+		retireAt := notBefore.Add(m.cfg.GracePeriod)
+		if _, err := m.store.AddSigningKey(ctx, StoredKey{ID: k.id, PrivateKey: der, NotBefore: notBefore}, since, retireAt); err != nil {
+			return err
+		}
+
+		if stored, err = m.store.SigningKeys(ctx); err != nil {
+			return err
+		}
+	}
+
+	return m.load(stored, now)
+}
+
+// load replaces the keys with the configured and the given stored ones. The
+// newest stored key that has started signing becomes the active key, and
+// the configured keys retire GracePeriod after the first of them did.
+func (m *Manager) load(stored []StoredKey, now time.Time) error {
+	keys := make(map[string]*key)
+	var active *key
+	var activeSince, firstSince time.Time
+
+	for _, s := range stored {
+		private, err := x509.ParsePKCS8PrivateKey(s.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("parsing stored key %s: %w", s.ID, err)
+		}
+		k, err := newKey(private)
+		if err != nil {
+			return fmt.Errorf("parsing stored key %s: %w", s.ID, err)
+		}
+		if s.RetiresAt != nil {
+			k.retiresAt = *s.RetiresAt
+		}
+		keys[k.id] = k
+
+		if s.NotBefore.After(now) {
+			continue
+		}
+		if active == nil || s.NotBefore.After(activeSince) {
+			active, activeSince = k, s.NotBefore
+		}
+		if firstSince.IsZero() || s.NotBefore.Before(firstSince) {
+			firstSince = s.NotBefore
+		}
+	}
+
+	for _, k := range m.configured {
+		configured := *k
+		if active != nil {
+			configured.retiresAt = firstSince.Add(m.cfg.GracePeriod)
+		}
+		keys[k.id] = &configured
+	}
+
+	if active == nil {
+		if m.fallback == nil {
+			return ErrNoKey
+		}
+		active = keys[m.fallback.id]
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = keys
+	m.active = active
+	return nil
+}
+
+// Sign signs claims with the active key and names it in the kid header.
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	k := m.active
+	m.mu.RUnlock()
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(k.alg), claims)
+	token.Header["kid"] = k.id
+	return token.SignedString(k.signing)
+}
+
+// Keyfunc finds the key a token was signed with by its kid header, for use
+// with jwt.Parse.
+func (m *Manager) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	m.mu.RLock()
+	k, ok := m.keys[kid]
+	m.mu.RUnlock()
+
+	if !ok || (!k.retiresAt.IsZero() && time.Now().After(k.retiresAt)) {
+		return nil, errors.New("unknown signing key")
+	}
+	if token.Method.Alg() != k.alg {
+		return nil, errors.New("wrong signing method")
+	}
+	return k.verifying, nil
+}
+
+// Algorithms lists the algorithms of the keys that can currently verify.
+func (m *Manager) Algorithms() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var algs []string
+	for _, k := range m.keys {
+		if !seen[k.alg] {
+			seen[k.alg] = true
+			algs = append(algs, k.alg)
+		}
+	}
+	return algs
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the public halves of the keys that currently verify tokens.
+// Shared HS256 secrets are never published.
+func (m *Manager) JWKS() JWKSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range m.keys {
+		if !k.retiresAt.IsZero() && now.After(k.retiresAt) {
+			continue
+		}
+		switch pub := k.verifying.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: k.id,
+				Use: "sig",
+				Alg: k.alg,
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: k.id,
+				Use: "sig",
+				Alg: k.alg,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return set
+}
+
+func loadKeyFile(path string) (*key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var private any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newKey(private)
+}
+
+func generateKey(alg string) (*key, error) {
+	switch alg {
+	case AlgRS256:
+		private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(private)
+	case AlgEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(private)
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// newKey wraps an RSA or Ed25519 private key. Its kid is derived from the
+// public key, so every instance loading the same file agrees on it.
+func newKey(private any) (*key, error) {
+	var alg string
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		alg = AlgRS256
+	case ed25519.PrivateKey:
+		alg = AlgEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	public := private.(crypto.Signer).Public()
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	return &key{
+		id:        base64.RawURLEncoding.EncodeToString(sum[:12]),
+		alg:       alg,
+		signing:   private,
+		verifying: public,
+	}, nil
+}
@@ -0,0 +1,350 @@
+package jwtkeys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// memStore is a Store kept in memory, retiring keys the way the database
+// does.
+type memStore struct {
+	mu   sync.Mutex
+	keys []StoredKey
+}
+
+func (s *memStore) SigningKeys(ctx context.Context) ([]StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var keys []StoredKey
+	for _, k := range s.keys {
+		if k.RetiresAt == nil || k.RetiresAt.After(now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memStore) AddSigningKey(ctx context.Context, k StoredKey, since, retireAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.keys {
+		if stored.NotBefore.After(since) {
+			return false, nil
+		}
+	}
+
+	now := time.Now()
+	var keys []StoredKey
+	for _, stored := range s.keys {
+		if stored.RetiresAt != nil && !stored.RetiresAt.After(now) {
+			continue
+		}
+		if stored.RetiresAt == nil {
+			at := retireAt
+			stored.RetiresAt = &at
+		}
+		keys = append(keys, stored)
+	}
+	s.keys = append(keys, k)
+	return true, nil
+}
+
+// age moves every stored key d into the past, as if d had gone by.
+func (s *memStore) age(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.keys {
+		s.keys[i].NotBefore = s.keys[i].NotBefore.Add(-d)
+		if s.keys[i].RetiresAt != nil {
+			at := s.keys[i].RetiresAt.Add(-d)
+			s.keys[i].RetiresAt = &at
+		}
+	}
+}
+
+func writeKeyFile(t *testing.T, private any) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func sign(t *testing.T, m *Manager) string {
+	t.Helper()
+
+	token, err := m.Sign(jwt.MapClaims{"sub": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func kidOf(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func verifies(m *Manager, token string) bool {
+	_, err := jwt.Parse(token, m.Keyfunc)
+	return err == nil
+}
+
+func published(m *Manager) map[string]bool {
+	kids := make(map[string]bool)
+	for _, k := range m.JWKS().Keys {
+		kids[k.Kid] = true
+	}
+	return kids
+}
+
+func TestNewManager(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edFile := writeKeyFile(t, edKey)
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		store   Store
+		wantAlg string
+		wantErr bool
+	}{
+		{name: "secret", cfg: Config{Secret: "s"}, wantAlg: AlgHS256},
+		{name: "secret with its algorithm", cfg: Config{Secret: "s", Algorithm: AlgHS256}, wantAlg: AlgHS256},
+		{name: "secret with another algorithm", cfg: Config{Secret: "s", Algorithm: AlgRS256}, wantErr: true},
+		{name: "key file", cfg: Config{KeyFiles: []string{edFile}, Secret: "s"}, wantAlg: AlgEdDSA},
+		{name: "key file with its algorithm", cfg: Config{KeyFiles: []string{edFile}, Algorithm: AlgEdDSA}, wantAlg: AlgEdDSA},
+		{name: "key file with another algorithm", cfg: Config{KeyFiles: []string{edFile}, Algorithm: AlgRS256}, wantErr: true},
+		{name: "missing key file", cfg: Config{KeyFiles: []string{filepath.Join(t.TempDir(), "none.pem")}}, wantErr: true},
+		{name: "no key", cfg: Config{}, wantErr: true},
+		{name: "rotation", cfg: Config{Secret: "s", Algorithm: AlgEdDSA, RotationInterval: time.Hour}, store: &memStore{}, wantAlg: AlgEdDSA},
+		{name: "rotation of shared secrets", cfg: Config{Secret: "s", Algorithm: AlgHS256, RotationInterval: time.Hour}, store: &memStore{}, wantErr: true},
+		{name: "rotation without algorithm", cfg: Config{Secret: "s", RotationInterval: time.Hour}, store: &memStore{}, wantErr: true},
+		{name: "rotation without store", cfg: Config{Algorithm: AlgEdDSA, RotationInterval: time.Hour}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewManager(context.Background(), tt.cfg, tt.store)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewManager() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewManager() error = %v", err)
+			}
+
+			parsed, err := jwt.Parse(sign(t, m), m.Keyfunc)
+			if err != nil {
+				t.Fatalf("token does not verify: %v", err)
+			}
+			if alg := parsed.Method.Alg(); alg != tt.wantAlg {
+				t.Errorf("signed with %s, want %s", alg, tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestKeyfunc(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The Ed25519 key signs, the RSA key is kept from before a switch.
+	m, err := NewManager(context.Background(), Config{KeyFiles: []string{writeKeyFile(t, edKey), writeKeyFile(t, rsaKey)}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := newKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := kidOf(t, sign(t, m))
+
+	tokenWith := func(method jwt.SigningMethod, kid string, key any) string {
+		token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "1"})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "active key", token: tokenWith(jwt.SigningMethodEdDSA, signed, edKey), want: true},
+		{name: "older configured key", token: tokenWith(jwt.SigningMethodRS256, old.id, rsaKey), want: true},
+		{name: "no kid", token: tokenWith(jwt.SigningMethodEdDSA, "", edKey)},
+		{name: "unknown kid", token: tokenWith(jwt.SigningMethodEdDSA, "unknown", edKey)},
+		{name: "kid of another key", token: tokenWith(jwt.SigningMethodRS256, signed, rsaKey)},
+		{name: "public key used as HMAC secret", token: tokenWith(jwt.SigningMethodHS256, old.id, x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifies(m, tt.token); got != tt.want {
+				t.Errorf("verifies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if kids := published(m); len(kids) != 2 || !kids[signed] || !kids[old.id] {
+		t.Errorf("JWKS has %v, want %s and %s", kids, signed, old.id)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ctx := context.Background()
+	store := &memStore{}
+	cfg := Config{
+		Secret:           "s",
+		Algorithm:        AlgEdDSA,
+		RotationInterval: time.Hour,
+		GracePeriod:      10 * time.Minute,
+	}
+
+	m, err := NewManager(ctx, cfg, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := NewManager(ctx, Config{Secret: "s"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromSecret := sign(t, secret)
+
+	// The first generated key signs at once; the secret verifies through
+	// the grace period but is never published.
+	first := sign(t, m)
+	if len(store.keys) != 1 || kidOf(t, first) != store.keys[0].ID {
+		t.Fatalf("first token signed with %s, want the stored key", kidOf(t, first))
+	}
+	if !verifies(m, fromSecret) {
+		t.Error("secret stopped verifying before the grace period ended")
+	}
+	if kids := published(m); len(kids) != 1 || !kids[kidOf(t, first)] {
+		t.Errorf("JWKS has %v, want only %s", kids, kidOf(t, first))
+	}
+
+	// Once the key is due, a new one is stored but only signs two sync
+	// intervals later, while every instance publishes it already.
+	store.age(cfg.RotationInterval)
+	if err := m.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.keys) != 2 {
+		t.Fatalf("store has %d keys after rotation, want 2", len(store.keys))
+	}
+	next := store.keys[1]
+	if want := time.Now().Add(2 * m.syncInterval()); next.NotBefore.After(want) || next.NotBefore.Before(want.Add(-time.Minute)) {
+		t.Errorf("new key signs from %s, want about %s", next.NotBefore, want)
+	}
+	if kid := kidOf(t, sign(t, m)); kid != kidOf(t, first) {
+		t.Errorf("signed with %s before the new key was due", kid)
+	}
+	if kids := published(m); !kids[next.ID] || !kids[kidOf(t, first)] {
+		t.Errorf("JWKS has %v, want both generated keys", kids)
+	}
+
+	// Another instance starting now neither rotates again nor signs early.
+	other, err := NewManager(ctx, cfg, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.keys) != 2 {
+		t.Errorf("store has %d keys after a second instance started, want 2", len(store.keys))
+	}
+	if kid := kidOf(t, sign(t, other)); kid != kidOf(t, first) {
+		t.Errorf("second instance signed with %s before the new key was due", kid)
+	}
+
+	// When it is due the new key signs, and the old one verifies until it
+	// retires a grace period later.
+	keys, _ := store.SigningKeys(ctx)
+	if err := m.load(keys, next.NotBefore); err != nil {
+		t.Fatal(err)
+	}
+	if kid := kidOf(t, sign(t, m)); kid != next.ID {
+		t.Errorf("signed with %s once the new key was due, want %s", kid, next.ID)
+	}
+	if !verifies(m, first) {
+		t.Error("replaced key stopped verifying before its grace period ended")
+	}
+
+	store.age(2*m.syncInterval() + cfg.GracePeriod + time.Second)
+	keys, _ = store.SigningKeys(ctx)
+	if err := m.load(keys, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if verifies(m, first) {
+		t.Error("replaced key still verifies after its grace period")
+	}
+	if verifies(m, fromSecret) {
+		t.Error("secret still verifies after its grace period")
+	}
+	if kids := published(m); len(kids) != 1 || !kids[next.ID] {
+		t.Errorf("JWKS has %v, want only %s", kids, next.ID)
+	}
+}
+
+func TestSyncInterval(t *testing.T) {
+	tests := []struct {
+		rotation time.Duration
+		want     time.Duration
+	}{
+		{rotation: time.Nanosecond, want: time.Second},
+		{rotation: 3 * time.Second, want: time.Second},
+		{rotation: time.Minute, want: 15 * time.Second},
+		{rotation: 24 * time.Hour, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		m := &Manager{cfg: Config{RotationInterval: tt.rotation}}
+		if got := m.syncInterval(); got != tt.want {
+			t.Errorf("syncInterval() with rotation every %s = %s, want %s", tt.rotation, got, tt.want)
+		}
+	}
+}
@@ -4,10 +4,145 @@ import (
 	"time"
 )
 
+const (
+	RoleAdmin   = "admin"
+	RoleTeacher = "teacher"
+	RoleStudent = "student"
+	RoleParent  = "parent"
+)
+
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	UserID int
+	Role   string
+}
+
 type User struct {
-	ID       int    `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	Password      string `json:"password"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	StudentID     *int   `json:"student_id"`
+	// OIDCSubject is the user's subject at the single sign-on provider.
+	OIDCSubject *string `json:"-"`
+	// TwoFactorEnabled is set once TOTP enrollment has been confirmed.
+	TwoFactorEnabled bool `json:"-"`
+	Disabled         bool `json:"-"`
+}
+
+// UserAccount is a user as admins see it.
+type UserAccount struct {
+	ID               int       `json:"id"`
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	EmailVerified    bool      `json:"email_verified"`
+	StudentID        *int      `json:"student_id"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	SingleSignOn     bool      `json:"single_sign_on"`
+	Disabled         bool      `json:"disabled"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type UserFilter struct {
+	// Query matches part of the email.
+	Query    *string
+	Role     *string
+	Disabled *bool
+	Limit    int
+	Offset   int
+}
+
+type UserList struct {
+	Users []UserAccount `json:"users"`
+	Total int           `json:"total"`
+}
+
+const (
+	AuditUserRoleChanged   = "user.role_changed"
+	AuditUserDisabled      = "user.disabled"
+	AuditUserEnabled       = "user.enabled"
+	AuditUserPasswordReset = "user.password_reset"
+	AuditUserDeleted       = "user.deleted"
+)
+
+type AuditEntry struct {
+	ID           int            `json:"id"`
+	AdminID      int            `json:"admin_id"`
+	Action       string         `json:"action"`
+	TargetUserID *int           `json:"target_user_id"`
+	Details      map[string]any `json:"details"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// APIKeyScopes are the route groups, named by the first segment of their path,
+// that an API key can be allowed to call.
+var APIKeyScopes = []string{
+	"students",
+	"groups",
+	"schedules",
+	"attendance",
+	"assignments",
+	"grades",
+	"rankings",
+	"subjects",
+}
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Role       string     `json:"role"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// AuthTokens is the result of a login. When the account has two-factor
+// authentication enabled a password login only yields a ChallengeToken, to be
+// exchanged together with a code for the other tokens.
+type AuthTokens struct {
+	AccessToken       string `json:"token,omitempty"`
+	RefreshToken      string `json:"refresh_token,omitempty"`
+	ExpiresIn         int    `json:"expires_in"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	FamilyID  string     `json:"family_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	FamilyID   string    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current marks the session the request was made with.
+	Current bool `json:"current"`
 }
 
 type Assignment struct {
@@ -25,6 +160,16 @@ type Grade struct {
 	Mark         int `json:"mark"`
 }
 
+type StudentGrade struct {
+	ID             int       `json:"id"`
+	AssignmentID   int       `json:"assignment_id"`
+	AssignmentName string    `json:"assignment_name"`
+	SubjectName    string    `json:"subject_name"`
+	Weight         int       `json:"weight"`
+	Date           time.Time `json:"date"`
+	Mark           int       `json:"mark"`
+}
+
 type StudentGPA struct {
 	StudentID int     `json:"student_id"`
 	GPA       float64 `json:"gpa"`
@@ -50,6 +195,84 @@ type Student struct {
 	GroupID    int       `json:"group_id"`
 	Major      string    `json:"major"`
 	CourseYear int       `json:"course_year"`
+	// Status is one of the Status constants, enrolled for new students. It
+	// only changes through a StatusChange.
+	Status string `json:"status"`
+	// DeletedAt is set on archived students, which only admins can list.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Enrollment statuses of a student. Only enrolled students are active.
+const (
+	StatusApplicant     = "applicant"
+	StatusEnrolled      = "enrolled"
+	StatusAcademicLeave = "academic_leave"
+	StatusGraduated     = "graduated"
+	StatusExpelled      = "expelled"
+	StatusWithdrawn     = "withdrawn"
+)
+
+// StatusChange records a student moving from one status to another.
+type StatusChange struct {
+	ID            int       `json:"id"`
+	StudentID     int       `json:"student_id"`
+	FromStatus    string    `json:"from_status"`
+	ToStatus      string    `json:"to_status"`
+	Reason        string    `json:"reason"`
+	EffectiveDate time.Time `json:"effective_date"`
+	// ChangedBy is the user who made the change, nil once they are deleted.
+	ChangedBy *int      `json:"changed_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StudentExport is a student as exported, with the optional computed columns.
+type StudentExport struct {
+	Student
+	// GPA is nil when not asked for or when the student has no grades.
+	GPA *float64
+	// AttendanceRate is the share of classes attended, nil when not asked
+	// for or when no attendance was recorded.
+	AttendanceRate *float64
+}
+
+type StudentExportColumns struct {
+	GPA            bool
+	AttendanceRate bool
+}
+
+const (
+	ImportActionCreate = "create"
+	ImportActionUpdate = "update"
+)
+
+// StudentImport reports, row by row, what importing a file of students did or
+// would do in a dry run.
+type StudentImport struct {
+	DryRun  bool               `json:"dry_run"`
+	Created int                `json:"created"`
+	Updated int                `json:"updated"`
+	Invalid int                `json:"invalid"`
+	Rows    []StudentImportRow `json:"rows"`
+}
+
+type StudentImportRow struct {
+	// Row is the line in the file, the header being line 1.
+	Row       int      `json:"row"`
+	Action    string   `json:"action,omitempty"`
+	StudentID *int     `json:"student_id,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// GroupMembership is a span of time a student spent in a group.
+type GroupMembership struct {
+	ID        int `json:"id"`
+	StudentID int `json:"student_id"`
+	GroupID   int `json:"group_id"`
+	// ValidFrom is nil for the group the student started out in.
+	ValidFrom *time.Time `json:"valid_from"`
+	// ValidTo is the day the student left the group, nil while they are
+	// still in it.
+	ValidTo *time.Time `json:"valid_to"`
 }
 
 type Attendance struct {
@@ -60,12 +283,105 @@ type Attendance struct {
 	StudentID   int       `json:"student_id"`
 }
 
+// StudentSuggestion is a name search hit for autocomplete.
+type StudentSuggestion struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	GroupID int    `json:"group_id"`
+}
+
 type StudentFilter struct {
+	// Query fuzzily matches the name; results are then ranked by how well.
+	Query      *string
 	GroupID    *int
 	Major      *string
 	CourseYear *int
-	Limit      int
-	Offset     int
+	TeacherID  *int
+	// AsOf makes GroupID, TeacherID and Statuses match the groups students
+	// were in and the statuses they had on that day rather than their current
+	// ones.
+	AsOf *time.Time
+	// Statuses keeps students in one of these statuses; empty means all.
+	Statuses []string
+	// IncludeDeleted lists archived students too.
+	IncludeDeleted bool
+	// Sort lists columns to sort by, such as "name,-course_year"; a leading
+	// minus sorts descending.
+	Sort string
+	Page PageRequest
+}
+
+type AttendanceFilter struct {
+	StudentID   *int
+	SubjectName *string
+	TeacherID   *int
+	// IncludeInactive keeps the attendance of students who are not enrolled.
+	// Set it when StudentID is, so a student's own records never vanish.
+	IncludeInactive bool
+	Sort            string
+	Page            PageRequest
+}
+
+type AssignmentFilter struct {
+	SubjectName *string
+	TeacherID   *int
+	Sort        string
+	Page        PageRequest
+}
+
+type ScheduleFilter struct {
+	GroupID   *int
+	TeacherID *int
+	Page      PageRequest
+}
+
+type RankingFilter struct {
+	GroupID     *int
+	SubjectName *string
+	// AsOf ranks the group as it was on that day, counting the grades of
+	// assignments up to then.
+	AsOf *time.Time
+	// IncludeInactive ranks students who are not enrolled too.
+	IncludeInactive bool
+	Page            PageRequest
+}
+
+// PageRequest asks for one page of a list. Cursor is the next_cursor or
+// prev_cursor of another page, or empty for the first one.
+type PageRequest struct {
+	Cursor string
+	Limit  int
+}
+
+// Page is one page of a list along with the size of the whole list.
+type Page[T any] struct {
+	Items      []T     `json:"items"`
+	Total      int     `json:"total"`
+	NextCursor *string `json:"next_cursor"`
+	PrevCursor *string `json:"prev_cursor"`
+}
+
+type GuardianInvitation struct {
+	ID         int        `json:"id"`
+	Email      string     `json:"email"`
+	StudentIDs []int      `json:"student_ids"`
+	TokenHash  string     `json:"-"`
+	InvitedBy  *int       `json:"invited_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+}
+
+type Teacher struct {
+	ID      int             `json:"id"`
+	UserID  *int            `json:"user_id"`
+	Name    string          `json:"name"`
+	Classes []TeachingClass `json:"classes"`
+}
+
+type TeachingClass struct {
+	SubjectName string `json:"subject_name"`
+	GroupID     int    `json:"group_id"`
 }
 
 type Subject struct {
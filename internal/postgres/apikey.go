@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+func (r *Repository) CreateAPIKey(ctx context.Context, k *models.APIKey) (int, error) {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, role, scopes)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+	err := r.db.QueryRow(ctx, query,
+		k.UserID, k.Name, k.Prefix, k.KeyHash, k.Role, k.Scopes,
+	).Scan(&k.ID, &k.CreatedAt)
+	return k.ID, err
+}
+
+func (r *Repository) GetAPIKeysByUserID(ctx context.Context, userID int) ([]models.APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, key_hash, role, scopes, created_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY id
+	`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// UseAPIKey looks up an unrevoked key of an enabled user by hash and records
+// that it was used.
+func (r *Repository) UseAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = now()
+		WHERE key_hash = $1 AND revoked_at IS NULL
+			AND user_id IN (SELECT id FROM users WHERE disabled_at IS NULL)
+		RETURNING id, user_id, name, prefix, key_hash, role, scopes, created_at, last_used_at, revoked_at
+	`
+	return scanAPIKey(r.db.QueryRow(ctx, query, keyHash))
+}
+
+// RevokeAPIKey revokes one of the user's keys, returning pgx.ErrNoRows if the
+// user has no live key with that id.
+func (r *Repository) RevokeAPIKey(ctx context.Context, userID, id int) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+	tag, err := r.db.Exec(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	var k models.APIKey
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &k.Role, &k.Scopes, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/ansarctica/domashka4/internal/models"
 )
@@ -18,40 +19,85 @@ func (r *Repository) CreateAttendance(ctx context.Context, a *models.Attendance)
 	).Scan(&id)
 	return id, err
 }
-func (r *Repository) GetAttendanceBySubjectName(ctx context.Context, subjectName string) ([]models.Attendance, error) {
+
+var attendanceSortColumns = sortColumns{
+	"id":           idKey,
+	"subject_name": {expr: "COALESCE(t.subject_name, '')", typ: "text"},
+	"visit_day":    {expr: "COALESCE(t.visit_day, '-infinity')", typ: "date"},
+	"visited":      {expr: "COALESCE(t.visited, false)", typ: "boolean"},
+	"student_id":   {expr: "COALESCE(t.student_id, 0)", typ: "int"},
+}
+
+// GetAttendance returns the page of attendance matching filter that
+// filter.Page asks for, in filter.Sort order or latest first. Filtering by
+// teacher keeps the subjects and groups they teach. Students who are not
+// enrolled are left out unless filter.IncludeInactive is set.
+func (r *Repository) GetAttendance(ctx context.Context, filter models.AttendanceFilter) (*models.Page[models.Attendance], error) {
 	query := `
-		SELECT id, subject_name, visit_day, visited, student_id
-		FROM attendance
-		WHERE subject_name = $1
+		SELECT a.id, a.subject_name, a.visit_day, a.visited, a.student_id
+		FROM attendance a
+		WHERE EXISTS (SELECT 1 FROM students s WHERE s.id = a.student_id AND s.deleted_at IS NULL)
 	`
-	return r.scanAttendance(ctx, query, subjectName)
+	var args []interface{}
+	argID := 1
+
+	if !filter.IncludeInactive {
+		query += " AND EXISTS (SELECT 1 FROM students s WHERE s.id = a.student_id AND s.status = 'enrolled')"
+	}
+
+	if filter.StudentID != nil {
+		query += fmt.Sprintf(" AND a.student_id = $%d", argID)
+		args = append(args, *filter.StudentID)
+		argID++
+	}
+
+	if filter.SubjectName != nil {
+		query += fmt.Sprintf(" AND a.subject_name = $%d", argID)
+		args = append(args, *filter.SubjectName)
+		argID++
+	}
+
+	if filter.TeacherID != nil {
+		query += fmt.Sprintf(` AND EXISTS (
+			SELECT 1 FROM students s
+			JOIN teaching_assignments t ON t.group_id = s.group_id
+			WHERE s.id = a.student_id AND t.subject_name = a.subject_name AND t.teacher_id = $%d
+		)`, argID)
+		args = append(args, *filter.TeacherID)
+		argID++
+	}
+
+	keys, err := attendanceSortColumns.keys(filter.Sort, []sortKey{
+		{expr: "COALESCE(t.visit_day, '-infinity')", typ: "date", desc: true},
+		idKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := listQuery{
+		base:    query,
+		args:    args,
+		columns: "t.id, t.subject_name, t.visit_day, t.visited, t.student_id",
+		keys:    keys,
+	}
+	return paginate(ctx, r, list, filter.Page, func(a *models.Attendance) []interface{} {
+		return []interface{}{&a.ID, &a.SubjectName, &a.VisitDay, &a.Visited, &a.StudentID}
+	})
 }
 
-func (r *Repository) GetAttendanceByStudentID(ctx context.Context, studentID int) ([]models.Attendance, error) {
+func (r *Repository) GetAttendanceByID(ctx context.Context, id int) (*models.Attendance, error) {
 	query := `
 		SELECT id, subject_name, visit_day, visited, student_id
 		FROM attendance
-		WHERE student_id = $1
+		WHERE id = $1
 	`
-	return r.scanAttendance(ctx, query, studentID)
-}
-
-func (r *Repository) scanAttendance(ctx context.Context, query string, args ...interface{}) ([]models.Attendance, error) {
-	rows, err := r.db.Query(ctx, query, args...)
+	var a models.Attendance
+	err := r.db.QueryRow(ctx, query, id).Scan(&a.ID, &a.SubjectName, &a.VisitDay, &a.Visited, &a.StudentID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var result []models.Attendance
-	for rows.Next() {
-		var a models.Attendance
-		if err := rows.Scan(&a.ID, &a.SubjectName, &a.VisitDay, &a.Visited, &a.StudentID); err != nil {
-			return nil, err
-		}
-		result = append(result, a)
-	}
-	return result, rows.Err()
+	return &a, nil
 }
 
 func (r *Repository) UpdateAttendance(ctx context.Context, a *models.Attendance) error {
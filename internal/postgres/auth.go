@@ -2,19 +2,34 @@ package postgres
 
 import (
 	"context"
+	"time"
 
 	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
 )
 
+const userColumns = `id, email, COALESCE(password_hash, ''), role, email_verified_at IS NOT NULL,
+	student_id, oidc_subject, totp_enabled_at IS NOT NULL, disabled_at IS NOT NULL`
+
+func scanUser(row pgx.Row) (*models.User, error) {
+	var u models.User
+	err := row.Scan(&u.ID, &u.Email, &u.Password, &u.Role, &u.EmailVerified,
+		&u.StudentID, &u.OIDCSubject, &u.TwoFactorEnabled, &u.Disabled)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
 func (r *Repository) CreateUser(ctx context.Context, user *models.User) (int, error) {
 	query := `
-		INSERT INTO users (email, password_hash)
-		VALUES ($1, $2)
+		INSERT INTO users (email, password_hash, role, email_verified_at, oidc_subject)
+		VALUES ($1, NULLIF($2, ''), $3, CASE WHEN $4 THEN now() END, $5)
 		RETURNING id
 	`
 
 	var id int
-	err := r.db.QueryRow(ctx, query, user.Email, user.Password).Scan(&id)
+	err := r.db.QueryRow(ctx, query, user.Email, user.Password, user.Role, user.EmailVerified, user.OIDCSubject).Scan(&id)
 
 	if err != nil {
 		return 0, err
@@ -25,32 +40,127 @@ func (r *Repository) CreateUser(ctx context.Context, user *models.User) (int, er
 
 func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	query := `
-		SELECT id, email, password_hash
+		SELECT ` + userColumns + `
 		FROM users
-		WHERE email = $1
+		WHERE lower(email) = lower($1)
 	`
 
-	row := r.db.QueryRow(ctx, query, email)
+	return scanUser(r.db.QueryRow(ctx, query, email))
+}
 
-	var u models.User
+func (r *Repository) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id = $1
+	`
+
+	return scanUser(r.db.QueryRow(ctx, query, id))
+}
+
+func (r *Repository) GetUserByOIDCSubject(ctx context.Context, subject string) (*models.User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE oidc_subject = $1
+	`
+
+	return scanUser(r.db.QueryRow(ctx, query, subject))
+}
+
+// LinkUserOIDCSubject ties an existing user to a provider subject. A user who
+// signs in through the provider has proven their email, so it is marked
+// verified as well.
+func (r *Repository) LinkUserOIDCSubject(ctx context.Context, userID int, subject string) error {
+	query := `
+		UPDATE users
+		SET oidc_subject = $1, email_verified_at = COALESCE(email_verified_at, now())
+		WHERE id = $2
+	`
+	_, err := r.db.Exec(ctx, query, subject, userID)
+	return err
+}
 
-	err := row.Scan(&u.ID, &u.Email, &u.Password)
+func (r *Repository) SetUserRole(ctx context.Context, userID int, role string) error {
+	tag, err := r.db.Exec(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, userID)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
 	}
+	return nil
+}
 
-	return &u, nil
+func (r *Repository) UpdateUserPassword(ctx context.Context, userID int, passwordHash string) error {
+	_, err := r.db.Exec(ctx, "UPDATE users SET password_hash = $1 WHERE id = $2", passwordHash, userID)
+	return err
 }
 
-func (r *Repository) GetUserByID(ctx context.Context, id int) (*models.User, error) {
-	query := `SELECT id, email, password_hash FROM users WHERE id = $1`
+// SetUserStudent links the user to a student record, or unlinks it when
+// studentID is nil. It returns pgx.ErrNoRows if the user does not exist.
+func (r *Repository) SetUserStudent(ctx context.Context, userID int, studentID *int) error {
+	tag, err := r.db.Exec(ctx, "UPDATE users SET student_id = $1 WHERE id = $2", studentID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
 
-	var u models.User
+func (r *Repository) MarkEmailVerified(ctx context.Context, userID int) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = now()
+		WHERE id = $1 AND email_verified_at IS NULL
+	`
+	_, err := r.db.Exec(ctx, query, userID)
+	return err
+}
 
-	err := r.db.QueryRow(ctx, query, id).Scan(&u.ID, &u.Email, &u.Password)
-	if err != nil {
-		return nil, err
+// GetLoginLock returns the latest time until which any of keys is locked out,
+// or the zero time if none of them is locked.
+func (r *Repository) GetLoginLock(ctx context.Context, keys []string) (time.Time, error) {
+	query := `
+		SELECT MAX(locked_until)
+		FROM login_attempts
+		WHERE key = ANY($1) AND locked_until > now()
+	`
+	var until *time.Time
+	err := r.db.QueryRow(ctx, query, keys).Scan(&until)
+	if err != nil || until == nil {
+		return time.Time{}, err
 	}
+	return *until, nil
+}
 
-	return &u, nil
+// RecordLoginFailure counts a failed login for key and returns the number of
+// failures in a row. Failures older than resetAfter no longer count.
+func (r *Repository) RecordLoginFailure(ctx context.Context, key string, resetAfter time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_attempts (key, failed_count, last_failed_at)
+		VALUES ($1, 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+			failed_count = CASE
+				WHEN login_attempts.last_failed_at < now() - $2::interval THEN 1
+				ELSE login_attempts.failed_count + 1
+			END,
+			last_failed_at = now()
+		RETURNING failed_count
+	`
+	var count int
+	err := r.db.QueryRow(ctx, query, key, resetAfter).Scan(&count)
+	return count, err
+}
+
+func (r *Repository) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := r.db.Exec(ctx, "UPDATE login_attempts SET locked_until = $1 WHERE key = $2", until, key)
+	return err
+}
+
+func (r *Repository) ClearLoginAttempts(ctx context.Context, keys []string) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM login_attempts WHERE key = ANY($1)", keys)
+	return err
 }
@@ -2,38 +2,67 @@ package postgres
 
 import (
 	"context"
-	"sort"
+	"fmt"
 
 	"github.com/ansarctica/domashka4/internal/models"
-	"github.com/jackc/pgx/v5"
 )
 
-func (r *Repository) GetAssignments(ctx context.Context, subjectName *string) ([]models.Assignment, error) {
-	query := `SELECT id, name, subject_name, weight, date FROM assignments`
+var assignmentSortColumns = sortColumns{
+	"id":           idKey,
+	"name":         {expr: "COALESCE(t.name, '')", typ: "text"},
+	"subject_name": {expr: "COALESCE(t.subject_name, '')", typ: "text"},
+	"weight":       {expr: "COALESCE(t.weight, 0)", typ: "int"},
+	"date":         {expr: "COALESCE(t.date, '-infinity')", typ: "date"},
+}
 
+// GetAssignments returns the page of assignments matching filter that
+// filter.Page asks for, in filter.Sort order or latest first. Filtering by
+// teacher keeps the subjects they teach.
+func (r *Repository) GetAssignments(ctx context.Context, filter models.AssignmentFilter) (*models.Page[models.Assignment], error) {
+	query := `SELECT id, name, subject_name, weight, date FROM assignments WHERE 1=1`
 	var args []interface{}
-	if subjectName != nil {
-		query += ` WHERE subject_name = $1`
-		args = append(args, *subjectName)
+	argID := 1
+
+	if filter.SubjectName != nil {
+		query += fmt.Sprintf(" AND subject_name = $%d", argID)
+		args = append(args, *filter.SubjectName)
+		argID++
 	}
 
-	query += ` ORDER BY date DESC`
+	if filter.TeacherID != nil {
+		query += fmt.Sprintf(" AND subject_name IN (SELECT subject_name FROM teaching_assignments WHERE teacher_id = $%d)", argID)
+		args = append(args, *filter.TeacherID)
+		argID++
+	}
 
-	rows, err := r.db.Query(ctx, query, args...)
+	keys, err := assignmentSortColumns.keys(filter.Sort, []sortKey{
+		{expr: "COALESCE(t.date, '-infinity')", typ: "date", desc: true},
+		idKey,
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var assignments []models.Assignment
-	for rows.Next() {
-		var a models.Assignment
-		if err := rows.Scan(&a.ID, &a.Name, &a.SubjectName, &a.Weight, &a.Date); err != nil {
-			return nil, err
-		}
-		assignments = append(assignments, a)
+	list := listQuery{
+		base:    query,
+		args:    args,
+		columns: "t.id, t.name, t.subject_name, t.weight, t.date",
+		keys:    keys,
 	}
-	return assignments, rows.Err()
+	return paginate(ctx, r, list, filter.Page, func(a *models.Assignment) []interface{} {
+		return []interface{}{&a.ID, &a.Name, &a.SubjectName, &a.Weight, &a.Date}
+	})
+}
+
+func (r *Repository) GetAssignmentByID(ctx context.Context, id int) (*models.Assignment, error) {
+	query := `SELECT id, name, subject_name, weight, date FROM assignments WHERE id = $1`
+
+	var a models.Assignment
+	err := r.db.QueryRow(ctx, query, id).Scan(&a.ID, &a.Name, &a.SubjectName, &a.Weight, &a.Date)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
 }
 
 func (r *Repository) CreateAssignment(ctx context.Context, a *models.Assignment) (int, error) {
@@ -58,6 +87,31 @@ func (r *Repository) CreateGrade(ctx context.Context, g *models.Grade) (int, err
 	return id, err
 }
 
+func (r *Repository) GetGradesByStudentID(ctx context.Context, studentID int) ([]models.StudentGrade, error) {
+	query := `
+		SELECT g.id, a.id, a.name, a.subject_name, a.weight, a.date, g.mark
+		FROM grades g
+		JOIN assignments a ON g.assignment_id = a.id
+		WHERE g.student_id = $1
+		ORDER BY a.date DESC, g.id
+	`
+	rows, err := r.db.Query(ctx, query, studentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grades := []models.StudentGrade{}
+	for rows.Next() {
+		var g models.StudentGrade
+		if err := rows.Scan(&g.ID, &g.AssignmentID, &g.AssignmentName, &g.SubjectName, &g.Weight, &g.Date, &g.Mark); err != nil {
+			return nil, err
+		}
+		grades = append(grades, g)
+	}
+	return grades, rows.Err()
+}
+
 func (r *Repository) GetGPAByStudentID(ctx context.Context, studentID int) (float64, error) {
 	query := `
 		SELECT 
@@ -98,98 +152,72 @@ func (r *Repository) calculateGPA(ctx context.Context, query string, args ...int
 	return weightedPoints / maxWeightedPoints, nil
 }
 
-func (r *Repository) GetGPARankingByGroup(ctx context.Context, groupID int) ([]models.StudentGPA, error) {
+// GetGPARanking returns the page of the GPA ranking that filter.Page asks
+// for, best first. The ranking covers a group, a subject, or one subject
+// within a group; students without grades are left out. As of a date, the
+// group is the one students were in then and later assignments don't count.
+// Only students enrolled at the time are ranked unless filter.IncludeInactive
+// is set.
+func (r *Repository) GetGPARanking(ctx context.Context, filter models.RankingFilter) (*models.Page[models.StudentGPA], error) {
 	query := `
-        SELECT 
+        SELECT
             g.student_id,
-            COALESCE(SUM(g.mark * a.weight), 0),
-            COALESCE(SUM(a.weight), 0)
-        FROM grades g
-        JOIN assignments a ON g.assignment_id = a.id
-        JOIN students s ON g.student_id = s.id 
-        WHERE s.group_id = $1
-        GROUP BY g.student_id
-    `
-	rows, err := r.db.Query(ctx, query, groupID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	return scanAndSortStudentGPAs(rows)
-}
-
-func (r *Repository) GetGPARankingBySubject(ctx context.Context, subjectName string) ([]models.StudentGPA, error) {
-	query := `
-        SELECT 
-            g.student_id,
-            COALESCE(SUM(g.mark * a.weight), 0),
-            COALESCE(SUM(a.weight), 0)
-        FROM grades g
-        JOIN assignments a ON g.assignment_id = a.id
-        WHERE a.subject_name = $1
-        GROUP BY g.student_id
-    `
-	rows, err := r.db.Query(ctx, query, subjectName)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	return scanAndSortStudentGPAs(rows)
-}
-
-func (r *Repository) GetSubjectGPARankingByGroup(ctx context.Context, subjectName string, groupID int) ([]models.StudentGPA, error) {
-	query := `
-        SELECT 
-            g.student_id,
-            COALESCE(SUM(g.mark * a.weight), 0),
-            COALESCE(SUM(a.weight), 0)
+            COALESCE(SUM(g.mark * a.weight)::float8 / NULLIF(SUM(a.weight), 0), 0) AS gpa
         FROM grades g
         JOIN assignments a ON g.assignment_id = a.id
         JOIN students s ON g.student_id = s.id
-        WHERE a.subject_name = $1 AND s.group_id = $2
-        GROUP BY g.student_id
+        WHERE s.deleted_at IS NULL
     `
-	rows, err := r.db.Query(ctx, query, subjectName, groupID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	return scanAndSortStudentGPAs(rows)
-}
-
-func scanAndSortStudentGPAs(rows pgx.Rows) ([]models.StudentGPA, error) {
-	var results []models.StudentGPA
+	var args []interface{}
+	argID := 1
 
-	for rows.Next() {
-		var studentID int
-		var weightedSum float64
-		var totalWeight float64
+	if filter.AsOf != nil {
+		query += fmt.Sprintf(" AND a.date <= $%d", argID)
+		args = append(args, *filter.AsOf)
+		argID++
+	}
 
-		if err := rows.Scan(&studentID, &weightedSum, &totalWeight); err != nil {
-			return nil, err
+	if !filter.IncludeInactive {
+		status := "s.status"
+		if filter.AsOf != nil {
+			status = statusAt("s", argID-1)
 		}
+		query += fmt.Sprintf(" AND %s = 'enrolled'", status)
+	}
 
-		var gpa float64
-		if totalWeight > 0 {
-			gpa = weightedSum / totalWeight
+	if filter.GroupID != nil {
+		if filter.AsOf != nil {
+			query += fmt.Sprintf(` AND EXISTS (
+				SELECT 1 FROM group_memberships m
+				WHERE m.student_id = s.id AND m.group_id = $%d
+					AND (m.valid_from IS NULL OR m.valid_from <= $%d)
+					AND (m.valid_to IS NULL OR m.valid_to > $%d)
+			)`, argID, argID-1, argID-1)
+		} else {
+			query += fmt.Sprintf(" AND s.group_id = $%d", argID)
 		}
-
-		results = append(results, models.StudentGPA{
-			StudentID: studentID,
-			GPA:       gpa,
-		})
+		args = append(args, *filter.GroupID)
+		argID++
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+	if filter.SubjectName != nil {
+		query += fmt.Sprintf(" AND a.subject_name = $%d", argID)
+		args = append(args, *filter.SubjectName)
+		argID++
 	}
 
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].GPA > results[j].GPA
-	})
+	query += " GROUP BY g.student_id"
 
-	return results, nil
+	list := listQuery{
+		base:    query,
+		args:    args,
+		columns: "t.student_id, t.gpa",
+		keys: []sortKey{
+			{expr: "t.gpa", typ: "float8", desc: true},
+			{expr: "t.student_id", typ: "int"},
+		},
+	}
+	return paginate(ctx, r, list, filter.Page, func(g *models.StudentGPA) []interface{} {
+		return []interface{}{&g.StudentID, &g.GPA}
+	})
 }
@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/ansarctica/domashka4/internal/models"
+)
+
+func (r *Repository) CreateGuardianInvitation(ctx context.Context, inv *models.GuardianInvitation) (int, error) {
+	query := `
+		INSERT INTO guardian_invitations (email, student_ids, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	err := r.db.QueryRow(ctx, query,
+		inv.Email, inv.StudentIDs, inv.TokenHash, inv.InvitedBy, inv.ExpiresAt,
+	).Scan(&inv.ID, &inv.CreatedAt)
+	return inv.ID, err
+}
+
+// GetPendingGuardianInvitation returns an invitation that has neither been
+// accepted nor expired, or pgx.ErrNoRows.
+func (r *Repository) GetPendingGuardianInvitation(ctx context.Context, tokenHash string) (*models.GuardianInvitation, error) {
+	query := `
+		SELECT id, email, student_ids, token_hash, invited_by, created_at, expires_at, accepted_at
+		FROM guardian_invitations
+		WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > now()
+	`
+	var inv models.GuardianInvitation
+	err := r.db.QueryRow(ctx, query, tokenHash).Scan(
+		&inv.ID, &inv.Email, &inv.StudentIDs, &inv.TokenHash, &inv.InvitedBy,
+		&inv.CreatedAt, &inv.ExpiresAt, &inv.AcceptedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// AcceptGuardianInvitation links the invited students to the guardian and
+// marks the invitation as used, all or nothing. It returns pgx.ErrNoRows if
+// the invitation was accepted in the meantime.
+func (r *Repository) AcceptGuardianInvitation(ctx context.Context, id, userID int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var studentIDs []int
+	err = tx.QueryRow(ctx, `
+		UPDATE guardian_invitations
+		SET accepted_at = now()
+		WHERE id = $1 AND accepted_at IS NULL
+		RETURNING student_ids
+	`, id).Scan(&studentIDs)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO guardian_students (user_id, student_id)
+		SELECT $1, s.id FROM students s WHERE s.id = ANY($2)
+		ON CONFLICT DO NOTHING
+	`, userID, studentIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *Repository) IsGuardianOf(ctx context.Context, userID, studentID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM guardian_students WHERE user_id = $1 AND student_id = $2)`
+
+	var ok bool
+	err := r.db.QueryRow(ctx, query, userID, studentID).Scan(&ok)
+	return ok, err
+}
+
+func (r *Repository) GetStudentsByGuardianID(ctx context.Context, userID int) ([]models.Student, error) {
+	query := `
+		SELECT s.id, s.name, s.birth_date, s.gender, s.group_id, s.major, s.course_year, s.status
+		FROM students s
+		JOIN guardian_students g ON g.student_id = s.id
+		WHERE g.user_id = $1 AND s.deleted_at IS NULL
+		ORDER BY s.id
+	`
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var students []models.Student
+	for rows.Next() {
+		var s models.Student
+		if err := rows.Scan(&s.ID, &s.Name, &s.BirthDate, &s.Gender, &s.GroupID, &s.Major, &s.CourseYear, &s.Status); err != nil {
+			return nil, err
+		}
+		students = append(students, s)
+	}
+	return students, rows.Err()
+}
+
+// CountStudents returns how many of ids belong to existing students.
+func (r *Repository) CountStudents(ctx context.Context, ids []int) (int, error) {
+	var n int
+	err := r.db.QueryRow(ctx, `SELECT count(*) FROM students WHERE id = ANY($1) AND deleted_at IS NULL`, ids).Scan(&n)
+	return n, err
+}
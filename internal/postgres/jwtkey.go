@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/jwtkeys"
+)
+
+// SigningKeys returns the generated JWT signing keys that have not retired.
+func (r *Repository) SigningKeys(ctx context.Context) ([]jwtkeys.StoredKey, error) {
+	query := `
+		SELECT kid, private_key, not_before, retires_at
+		FROM jwt_signing_keys
+		WHERE retires_at IS NULL OR retires_at > now()
+		ORDER BY not_before
+	`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []jwtkeys.StoredKey
+	for rows.Next() {
+		var k jwtkeys.StoredKey
+		if err := rows.Scan(&k.ID, &k.PrivateKey, &k.NotBefore, &k.RetiresAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// AddSigningKey stores a generated JWT signing key and retires the current
+// ones at retireAt, unless a key signing after since is already there. The
+// table lock makes instances rotating at the same moment take turns, so only
+// the first of them adds a key. Retired keys are cleared on the way.
+func (r *Repository) AddSigningKey(ctx context.Context, k jwtkeys.StoredKey, since, retireAt time.Time) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "LOCK TABLE jwt_signing_keys IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return false, err
+	}
+
+	var rotated bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM jwt_signing_keys WHERE not_before > $1)", since).Scan(&rotated)
+	if err != nil {
+		return false, err
+	}
+	if rotated {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM jwt_signing_keys WHERE retires_at <= now()"); err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE jwt_signing_keys SET retires_at = $1 WHERE retires_at IS NULL", retireAt)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO jwt_signing_keys (kid, private_key, not_before)
+		VALUES ($1, $2, $3)
+	`, k.ID, k.PrivateKey, k.NotBefore)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// GetGroupMemberships returns the groups a student has been in, earliest
+// first.
+func (r *Repository) GetGroupMemberships(ctx context.Context, studentID int) ([]models.GroupMembership, error) {
+	query := `
+		SELECT id, student_id, group_id, valid_from, valid_to
+		FROM group_memberships
+		WHERE student_id = $1
+		ORDER BY valid_from NULLS FIRST, id
+	`
+	rows, err := r.db.Query(ctx, query, studentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []models.GroupMembership{}
+	for rows.Next() {
+		var m models.GroupMembership
+		if err := rows.Scan(&m.ID, &m.StudentID, &m.GroupID, &m.ValidFrom, &m.ValidTo); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+	return memberships, rows.Err()
+}
+
+// GetCurrentGroupMembership returns the membership of the group a student is
+// in now.
+func (r *Repository) GetCurrentGroupMembership(ctx context.Context, studentID int) (*models.GroupMembership, error) {
+	query := `
+		SELECT id, student_id, group_id, valid_from, valid_to
+		FROM group_memberships
+		WHERE student_id = $1 AND valid_to IS NULL
+	`
+	var m models.GroupMembership
+	err := r.db.QueryRow(ctx, query, studentID).Scan(&m.ID, &m.StudentID, &m.GroupID, &m.ValidFrom, &m.ValidTo)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// TransferStudent moves a student to another group from the given day on. It
+// returns pgx.ErrNoRows if there is no such student or it is archived.
+func (r *Repository) TransferStudent(ctx context.Context, studentID, groupID int, from time.Time) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "UPDATE students SET group_id = $1 WHERE id = $2 AND deleted_at IS NULL", groupID, studentID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	if err := recordTransfer(ctx, tx, studentID, groupID, from); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// addMembership starts a new student off in their first group.
+func addMembership(ctx context.Context, tx pgx.Tx, studentID, groupID int) error {
+	_, err := tx.Exec(ctx, "INSERT INTO group_memberships (student_id, group_id) VALUES ($1, $2)", studentID, groupID)
+	return err
+}
+
+// recordTransfer ends a student's current membership at from and opens one
+// in groupID. A membership that only started on from is moved to groupID
+// instead, so several changes on one day leave a single entry.
+func recordTransfer(ctx context.Context, tx pgx.Tx, studentID, groupID int, from time.Time) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE group_memberships SET group_id = $1
+		WHERE student_id = $2 AND valid_to IS NULL AND valid_from = $3
+	`, groupID, studentID, from)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE group_memberships SET valid_to = $1
+		WHERE student_id = $2 AND valid_to IS NULL
+	`, from, studentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO group_memberships (student_id, group_id, valid_from)
+		VALUES ($1, $2, $3)
+	`, studentID, groupID, from)
+	return err
+}
+
+// syncMembership records a change of group made by editing a student as a
+// transfer effective today.
+func syncMembership(ctx context.Context, tx pgx.Tx, studentID, oldGroupID, newGroupID int) error {
+	if oldGroupID == newGroupID {
+		return nil
+	}
+	return recordTransfer(ctx, tx, studentID, newGroupID, Today())
+}
+
+// Today is the current day as DATE columns are scanned: midnight UTC.
+func Today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,186 @@
+package postgres
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// listQuery is a list to paginate. The rows of base are available to columns
+// and keys as t.
+type listQuery struct {
+	base    string
+	args    []interface{}
+	columns string
+	// keys order the list. Together they must be unique, so the last one is
+	// usually t.id; nullable columns need a COALESCE.
+	keys []sortKey
+}
+
+type sortKey struct {
+	expr string
+	// typ is the SQL type the cursor value is cast back to.
+	typ  string
+	desc bool
+}
+
+// cursor marks the row a page starts after, by the text of its sort keys.
+type cursor struct {
+	Sort     string   `json:"s"`
+	Keys     []string `json:"k"`
+	Backward bool     `json:"b,omitempty"`
+}
+
+func (c cursor) encode() *string {
+	data, _ := json.Marshal(c)
+	s := base64.RawURLEncoding.EncodeToString(data)
+	return &s
+}
+
+func decodeCursor(s string, keys []sortKey) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || len(c.Keys) != len(keys) || c.Sort != sortSignature(keys) {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// paginate returns the page of list that req asks for, scanning each row into
+// the fields of a T.
+func paginate[T any](ctx context.Context, r *Repository, list listQuery, req models.PageRequest, fields func(*T) []interface{}) (*models.Page[T], error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	limit = min(limit, maxPageSize)
+
+	var after cursor
+	if req.Cursor != "" {
+		var err error
+		if after, err = decodeCursor(req.Cursor, list.keys); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &models.Page[T]{Items: []T{}}
+	err := r.db.QueryRow(ctx, "SELECT count(*) FROM ("+list.base+") AS t", list.args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	args := slices.Clone(list.args)
+	var selected, order []string
+	for _, k := range list.keys {
+		dir := "ASC"
+		if k.desc != after.Backward {
+			dir = "DESC"
+		}
+		selected = append(selected, fmt.Sprintf("(%s)::text", k.expr))
+		order = append(order, k.expr+" "+dir)
+	}
+
+	// A row comes after the cursor if it ties on the first keys and is past
+	// it on the next one.
+	where := "TRUE"
+	if req.Cursor != "" {
+		var or []string
+		for i, k := range list.keys {
+			var and []string
+			for j := range i {
+				args = append(args, after.Keys[j])
+				and = append(and, fmt.Sprintf("%s = $%d::%s", list.keys[j].expr, len(args), list.keys[j].typ))
+			}
+			op := ">"
+			if k.desc != after.Backward {
+				op = "<"
+			}
+			args = append(args, after.Keys[i])
+			and = append(and, fmt.Sprintf("%s %s $%d::%s", k.expr, op, len(args), k.typ))
+			or = append(or, "("+strings.Join(and, " AND ")+")")
+		}
+		where = strings.Join(or, " OR ")
+	}
+
+	query := fmt.Sprintf("SELECT %s, %s FROM (%s) AS t WHERE %s ORDER BY %s LIMIT %d",
+		list.columns, strings.Join(selected, ", "), list.base, where, strings.Join(order, ", "), limit+1)
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, cursorError(req, err)
+	}
+	defer rows.Close()
+
+	var keys [][]string
+	for rows.Next() {
+		var item T
+		rowKeys := make([]string, len(list.keys))
+		dest := fields(&item)
+		for i := range rowKeys {
+			dest = append(dest, &rowKeys[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, item)
+		keys = append(keys, rowKeys)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, cursorError(req, err)
+	}
+
+	more := len(page.Items) > limit
+	if more {
+		page.Items = page.Items[:limit]
+		keys = keys[:limit]
+	}
+	if after.Backward {
+		slices.Reverse(page.Items)
+		slices.Reverse(keys)
+	}
+	if len(page.Items) == 0 {
+		return page, nil
+	}
+
+	// Going forward there is a previous page unless this is the first one;
+	// going back there is always a next page, the one we came from.
+	hasNext, hasPrev := more, req.Cursor != ""
+	if after.Backward {
+		hasNext, hasPrev = true, more
+	}
+	sort := sortSignature(list.keys)
+	if hasNext {
+		page.NextCursor = cursor{Sort: sort, Keys: keys[len(keys)-1]}.encode()
+	}
+	if hasPrev {
+		page.PrevCursor = cursor{Sort: sort, Keys: keys[0], Backward: true}.encode()
+	}
+	return page, nil
+}
+
+// cursorError reports a cursor whose keys Postgres could not read back as
+// invalid rather than as a server error.
+func cursorError(req models.PageRequest, err error) error {
+	var pgErr *pgconn.PgError
+	if req.Cursor != "" && errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "22") {
+		return ErrInvalidCursor
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	keys := []sortKey{{expr: "t.name", typ: "text"}, {expr: "t.date", typ: "date", desc: true}, idKey}
+
+	tests := []struct {
+		name   string
+		cursor cursor
+	}{
+		{"forward", cursor{Sort: sortSignature(keys), Keys: []string{"Ann", "2024-01-02", "7"}}},
+		{"backward", cursor{Sort: sortSignature(keys), Keys: []string{"Bob", "2023-12-31", "12"}, Backward: true}},
+		{"awkward text", cursor{Sort: sortSignature(keys), Keys: []string{`O'Brien, "Jr."`, "", "1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(*tt.cursor.encode(), keys)
+			if err != nil {
+				t.Fatalf("decodeCursor() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.cursor) {
+				t.Errorf("decodeCursor() = %+v, want %+v", got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	keys := []sortKey{{expr: "t.name", typ: "text"}, idKey}
+	other := []sortKey{{expr: "t.name", typ: "text", desc: true}, idKey}
+	encodeRaw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "***"},
+		{"not JSON", encodeRaw("not json")},
+		{"too few keys", *cursor{Sort: sortSignature(keys), Keys: []string{"a"}}.encode()},
+		{"too many keys", *cursor{Sort: sortSignature(keys), Keys: []string{"a", "1", "x"}}.encode()},
+		{"other sort order", *cursor{Sort: sortSignature(other), Keys: []string{"a", "1"}}.encode()},
+		{"no sort", encodeRaw(`{"k":["a","1"]}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.cursor, keys); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor() error = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
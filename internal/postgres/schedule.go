@@ -2,17 +2,51 @@ package postgres
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/ansarctica/domashka4/internal/models"
 )
 
-func (r *Repository) GetAllGroupSchedules(ctx context.Context) ([]models.Schedule, error) {
+// GetSchedules returns the page of schedules matching filter that
+// filter.Page asks for, by group and start time. Filtering by teacher keeps
+// their own classes.
+func (r *Repository) GetSchedules(ctx context.Context, filter models.ScheduleFilter) (*models.Page[models.Schedule], error) {
 	query := `
-		SELECT id, group_id, subject_name, start_time, end_time
-		FROM schedule
-		ORDER BY group_id, start_time
+		SELECT s.id, s.group_id, s.subject_name, s.start_time, s.end_time
+		FROM schedule s
+		WHERE 1=1
 	`
-	return r.scanSchedules(ctx, query)
+	var args []interface{}
+	argID := 1
+
+	if filter.GroupID != nil {
+		query += fmt.Sprintf(" AND s.group_id = $%d", argID)
+		args = append(args, *filter.GroupID)
+		argID++
+	}
+
+	if filter.TeacherID != nil {
+		query += fmt.Sprintf(` AND EXISTS (
+			SELECT 1 FROM teaching_assignments t
+			WHERE t.subject_name = s.subject_name AND t.group_id = s.group_id AND t.teacher_id = $%d
+		)`, argID)
+		args = append(args, *filter.TeacherID)
+		argID++
+	}
+
+	list := listQuery{
+		base:    query,
+		args:    args,
+		columns: "t.id, t.group_id, t.subject_name, t.start_time, t.end_time",
+		keys: []sortKey{
+			{expr: "COALESCE(t.group_id, 0)", typ: "int"},
+			{expr: "COALESCE(t.start_time, '00:00')", typ: "time"},
+			idKey,
+		},
+	}
+	return paginate(ctx, r, list, filter.Page, func(s *models.Schedule) []interface{} {
+		return []interface{}{&s.ID, &s.GroupID, &s.Subject, &s.StartTime, &s.EndTime}
+	})
 }
 
 func (r *Repository) GetGroupScheduleByID(ctx context.Context, groupID int) ([]models.Schedule, error) {
@@ -43,6 +77,17 @@ func (r *Repository) scanSchedules(ctx context.Context, query string, args ...in
 	return result, rows.Err()
 }
 
+func (r *Repository) GetScheduleByID(ctx context.Context, id int) (*models.Schedule, error) {
+	query := `SELECT id, group_id, subject_name, start_time, end_time FROM schedule WHERE id = $1`
+
+	var s models.Schedule
+	err := r.db.QueryRow(ctx, query, id).Scan(&s.ID, &s.GroupID, &s.Subject, &s.StartTime, &s.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
 func (r *Repository) CreateSchedule(ctx context.Context, s *models.Schedule) (int, error) {
 	query := `
 		INSERT INTO schedule (group_id, subject_name, start_time, end_time)
@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+)
+
+const sessionColumns = `id, user_id, family_id, user_agent, ip, created_at, last_seen_at, expires_at`
+
+func (r *Repository) CreateSession(ctx context.Context, s *models.Session) (int, error) {
+	query := `
+		INSERT INTO sessions (user_id, family_id, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+	var id int
+	err := r.db.QueryRow(ctx, query, s.UserID, s.FamilyID, s.UserAgent, s.IP, s.ExpiresAt).Scan(&id)
+	return id, err
+}
+
+// GetSessionByFamilyID returns the live session of a refresh token family.
+func (r *Repository) GetSessionByFamilyID(ctx context.Context, familyID string) (*models.Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE family_id = $1 AND terminated_at IS NULL
+	`
+
+	var s models.Session
+	err := r.db.QueryRow(ctx, query, familyID).Scan(&s.ID, &s.UserID, &s.FamilyID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSession returns the live session with id if it belongs to userID.
+func (r *Repository) GetSession(ctx context.Context, id, userID int) (*models.Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE id = $1 AND user_id = $2 AND terminated_at IS NULL AND expires_at > now()
+	`
+
+	var s models.Session
+	err := r.db.QueryRow(ctx, query, id, userID).Scan(&s.ID, &s.UserID, &s.FamilyID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetSessionsByUserID lists the user's live sessions, most recently used
+// first.
+func (r *Repository) GetSessionsByUserID(ctx context.Context, userID int) ([]models.Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions
+		WHERE user_id = $1 AND terminated_at IS NULL AND expires_at > now()
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var s models.Session
+		err := rows.Scan(&s.ID, &s.UserID, &s.FamilyID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// ExtendSession records a refresh of the session and moves its expiry.
+func (r *Repository) ExtendSession(ctx context.Context, id int, expiresAt time.Time) error {
+	query := `
+		UPDATE sessions
+		SET last_seen_at = now(), expires_at = $2
+		WHERE id = $1
+	`
+	_, err := r.db.Exec(ctx, query, id, expiresAt)
+	return err
+}
+
+// GetSessionActivity reports whether the session is still live and when it
+// was last seen. It returns pgx.ErrNoRows if the user has no such session.
+func (r *Repository) GetSessionActivity(ctx context.Context, id, userID int) (bool, time.Time, error) {
+	query := `
+		SELECT terminated_at IS NULL, last_seen_at
+		FROM sessions
+		WHERE id = $1 AND user_id = $2
+	`
+	var live bool
+	var lastSeen time.Time
+	err := r.db.QueryRow(ctx, query, id, userID).Scan(&live, &lastSeen)
+	return live, lastSeen, err
+}
+
+func (r *Repository) TouchSession(ctx context.Context, id int) error {
+	_, err := r.db.Exec(ctx, "UPDATE sessions SET last_seen_at = now() WHERE id = $1", id)
+	return err
+}
+
+// TerminateSessionsExcept ends all of the user's sessions but keepID and
+// revokes their refresh tokens.
+func (r *Repository) TerminateSessionsExcept(ctx context.Context, userID, keepID int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		UPDATE sessions
+		SET terminated_at = now()
+		WHERE user_id = $1 AND id <> $2 AND terminated_at IS NULL
+		RETURNING family_id
+	`, userID, keepID)
+	if err != nil {
+		return err
+	}
+	var families []string
+	for rows.Next() {
+		var family string
+		if err := rows.Scan(&family); err != nil {
+			rows.Close()
+			return err
+		}
+		families = append(families, family)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = now()
+		WHERE family_id = ANY($1) AND revoked_at IS NULL
+	`, families)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
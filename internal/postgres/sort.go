@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidSort = errors.New("invalid sort")
+
+// sortColumns are the columns a list may be sorted by. Their keys must not be
+// NULL, so nullable columns are wrapped in a COALESCE.
+type sortColumns map[string]sortKey
+
+var idKey = sortKey{expr: "t.id", typ: "int"}
+
+// keys turns a sort such as "name,-course_year" into sort keys, or returns
+// def if sort is empty. Ties are broken by id.
+func (c sortColumns) keys(sort string, def []sortKey) ([]sortKey, error) {
+	if sort == "" {
+		return def, nil
+	}
+
+	var keys []sortKey
+	seen := map[string]bool{}
+	for _, name := range strings.Split(sort, ",") {
+		name = strings.TrimSpace(name)
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		key, ok := c[name]
+		if !ok || seen[name] {
+			return nil, fmt.Errorf("%w: cannot sort by %q", ErrInvalidSort, name)
+		}
+		seen[name] = true
+
+		key.desc = desc
+		keys = append(keys, key)
+	}
+
+	if !seen["id"] {
+		keys = append(keys, idKey)
+	}
+	return keys, nil
+}
+
+// sortSignature identifies an order, so cursors cannot be carried over to a
+// list sorted differently.
+func sortSignature(keys []sortKey) string {
+	var parts []string
+	for _, k := range keys {
+		if k.desc {
+			parts = append(parts, "-"+k.expr)
+		} else {
+			parts = append(parts, k.expr)
+		}
+	}
+	return strings.Join(parts, ",")
+}
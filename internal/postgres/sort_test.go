@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSortColumnsKeys(t *testing.T) {
+	name := studentSortColumns["name"]
+	courseYear := studentSortColumns["course_year"]
+	desc := func(k sortKey) sortKey {
+		k.desc = true
+		return k
+	}
+	def := []sortKey{desc(courseYear), idKey}
+
+	tests := []struct {
+		name    string
+		sort    string
+		want    []sortKey
+		wantErr bool
+	}{
+		{name: "default", sort: "", want: def},
+		{name: "one column", sort: "name", want: []sortKey{name, idKey}},
+		{name: "descending", sort: "-name", want: []sortKey{desc(name), idKey}},
+		{name: "several columns", sort: "name,-course_year", want: []sortKey{name, desc(courseYear), idKey}},
+		{name: "spaces around names", sort: " name , -course_year ", want: []sortKey{name, desc(courseYear), idKey}},
+		{name: "id is not added twice", sort: "-id,name", want: []sortKey{desc(idKey), name}},
+		{name: "unknown column", sort: "password", wantErr: true},
+		{name: "raw SQL", sort: "name; DROP TABLE students", wantErr: true},
+		{name: "expression instead of name", sort: "t.name", wantErr: true},
+		{name: "repeated column", sort: "name,-name", wantErr: true},
+		{name: "empty entry", sort: "name,", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := studentSortColumns.keys(tt.sort, def)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSort) {
+					t.Errorf("keys() error = %v, want ErrInvalidSort", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("keys() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortSignature(t *testing.T) {
+	name := studentSortColumns["name"]
+	descName := name
+	descName.desc = true
+
+	if sortSignature([]sortKey{name, idKey}) == sortSignature([]sortKey{descName, idKey}) {
+		t.Error("ascending and descending orders share a signature")
+	}
+	if sortSignature([]sortKey{name, idKey}) == sortSignature([]sortKey{idKey, name}) {
+		t.Error("orders of the same keys share a signature")
+	}
+}
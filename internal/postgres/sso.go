@@ -0,0 +1,38 @@
+package postgres
+
+import (
+	"context"
+	"time"
+)
+
+// CreateOIDCLogin stores a started single sign-on attempt and clears out the
+// ones that were never finished.
+func (r *Repository) CreateOIDCLogin(ctx context.Context, stateHash, verifier, nonce string, expiresAt time.Time) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM oidc_logins WHERE expires_at < now()"); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO oidc_logins (state_hash, code_verifier, nonce, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := r.db.Exec(ctx, query, stateHash, verifier, nonce, expiresAt)
+	return err
+}
+
+// UseOIDCLogin consumes the unexpired attempt with stateHash and returns its
+// PKCE verifier and nonce. It returns pgx.ErrNoRows if there is none.
+func (r *Repository) UseOIDCLogin(ctx context.Context, stateHash string) (string, string, error) {
+	query := `
+		DELETE FROM oidc_logins
+		WHERE state_hash = $1 AND expires_at > now()
+		RETURNING code_verifier, nonce
+	`
+
+	var verifier, nonce string
+	err := r.db.QueryRow(ctx, query, stateHash).Scan(&verifier, &nonce)
+	if err != nil {
+		return "", "", err
+	}
+	return verifier, nonce, nil
+}
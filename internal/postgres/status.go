@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// statusAt is the SQL for the status the students row named table had on the
+// date in parameter dateArg: the one it last changed to by then, else the one
+// it next changed from, else its current one.
+func statusAt(table string, dateArg int) string {
+	return fmt.Sprintf(`COALESCE(
+		(SELECT c.to_status FROM student_status_changes c
+		 WHERE c.student_id = %[1]s.id AND c.effective_date <= $%[2]d
+		 ORDER BY c.effective_date DESC, c.id DESC LIMIT 1),
+		(SELECT c.from_status FROM student_status_changes c
+		 WHERE c.student_id = %[1]s.id AND c.effective_date > $%[2]d
+		 ORDER BY c.effective_date, c.id LIMIT 1),
+		%[1]s.status
+	)`, table, dateArg)
+}
+
+// ChangeStudentStatus moves a student from change.FromStatus to
+// change.ToStatus and records the change, setting its ID and CreatedAt. It
+// returns pgx.ErrNoRows if the student does not exist, is archived or is no
+// longer in change.FromStatus.
+func (r *Repository) ChangeStudentStatus(ctx context.Context, change *models.StatusChange) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE students SET status = $1
+		WHERE id = $2 AND status = $3 AND deleted_at IS NULL
+	`, change.ToStatus, change.StudentID, change.FromStatus)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO student_status_changes (student_id, from_status, to_status, reason, effective_date, changed_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, change.StudentID, change.FromStatus, change.ToStatus, change.Reason, change.EffectiveDate, change.ChangedBy,
+	).Scan(&change.ID, &change.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetStatusChanges returns the status changes of a student, earliest first.
+func (r *Repository) GetStatusChanges(ctx context.Context, studentID int) ([]models.StatusChange, error) {
+	query := `
+		SELECT id, student_id, from_status, to_status, reason, effective_date, changed_by, created_at
+		FROM student_status_changes
+		WHERE student_id = $1
+		ORDER BY effective_date, id
+	`
+	rows, err := r.db.Query(ctx, query, studentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.StatusChange{}
+	for rows.Next() {
+		var c models.StatusChange
+		err := rows.Scan(&c.ID, &c.StudentID, &c.FromStatus, &c.ToStatus, &c.Reason, &c.EffectiveDate, &c.ChangedBy, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
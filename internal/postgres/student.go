@@ -2,22 +2,146 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
 )
 
-func (r *Repository) GetAllStudents(ctx context.Context, filter models.StudentFilter) ([]models.Student, error) {
-	query := `
-		SELECT id, name, birth_date, gender, group_id, major, course_year
-		FROM students
-		WHERE 1=1
-	`
+var studentSortColumns = sortColumns{
+	"id":          idKey,
+	"name":        {expr: "COALESCE(t.name, '')", typ: "text"},
+	"birth_date":  {expr: "COALESCE(t.birth_date, '-infinity')", typ: "date"},
+	"gender":      {expr: "COALESCE(t.gender, '')", typ: "text"},
+	"group_id":    {expr: "COALESCE(t.group_id, 0)", typ: "int"},
+	"major":       {expr: "COALESCE(t.major, '')", typ: "text"},
+	"course_year": {expr: "COALESCE(t.course_year, 0)", typ: "int"},
+	"status":      {expr: "t.status", typ: "text"},
+}
+
+const studentColumns = "t.id, t.name, t.birth_date, t.gender, t.group_id, t.major, t.course_year, t.status, t.deleted_at"
+
+// GetAllStudents returns the page of students matching filter that
+// filter.Page asks for, in filter.Sort order. Without one they are ordered by
+// id or, when searching by name, best match first.
+func (r *Repository) GetAllStudents(ctx context.Context, filter models.StudentFilter) (*models.Page[models.Student], error) {
+	list, err := studentList(filter)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(ctx, r, list, filter.Page, func(s *models.Student) []interface{} {
+		return []interface{}{&s.ID, &s.Name, &s.BirthDate, &s.Gender, &s.GroupID, &s.Major, &s.CourseYear, &s.Status, &s.DeletedAt}
+	})
+}
+
+// ExportStudents calls fn with every student matching filter, in the same
+// order as GetAllStudents, reading them from the database as it goes. GPA and
+// attendance rate are only computed when asked for.
+func (r *Repository) ExportStudents(ctx context.Context, filter models.StudentFilter, columns models.StudentExportColumns, fn func(*models.StudentExport) error) error {
+	list, err := studentList(filter)
+	if err != nil {
+		return err
+	}
+
+	gpa, attendanceRate := "NULL::float8", "NULL::float8"
+	if columns.GPA {
+		gpa = `(
+			SELECT SUM(g.mark * a.weight)::float8 / NULLIF(SUM(a.weight), 0)
+			FROM grades g
+			JOIN assignments a ON g.assignment_id = a.id
+			WHERE g.student_id = t.id
+		)`
+	}
+	if columns.AttendanceRate {
+		attendanceRate = `(SELECT avg(visited::int)::float8 FROM attendance WHERE student_id = t.id)`
+	}
+
+	var order []string
+	for _, k := range list.keys {
+		if k.desc {
+			order = append(order, k.expr+" DESC")
+		} else {
+			order = append(order, k.expr)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s, %s, %s FROM (%s) AS t ORDER BY %s",
+		list.columns, gpa, attendanceRate, list.base, strings.Join(order, ", "))
+
+	rows, err := r.db.Query(ctx, query, list.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.StudentExport
+		err := rows.Scan(&e.ID, &e.Name, &e.BirthDate, &e.Gender, &e.GroupID, &e.Major, &e.CourseYear, &e.Status, &e.DeletedAt,
+			&e.GPA, &e.AttendanceRate)
+		if err != nil {
+			return err
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// studentList builds the query behind GetAllStudents and ExportStudents.
+func studentList(filter models.StudentFilter) (listQuery, error) {
 	var args []interface{}
 	argID := 1
 
+	// As of a date, students are listed in the group and status they had
+	// then.
+	group, status := "group_id", "status"
+	if filter.AsOf != nil {
+		status = statusAt("students", argID)
+		group = fmt.Sprintf(`(
+			SELECT m.group_id FROM group_memberships m
+			WHERE m.student_id = students.id
+				AND (m.valid_from IS NULL OR m.valid_from <= $%d)
+				AND (m.valid_to IS NULL OR m.valid_to > $%d)
+		)`, argID, argID)
+		args = append(args, *filter.AsOf)
+		argID++
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, name, birth_date, gender, %s AS group_id, major, course_year, %s AS status, deleted_at
+		FROM students
+		WHERE 1=1
+	`, group, status)
+
+	keys := []sortKey{idKey}
+
+	if filter.Query != nil {
+		query = fmt.Sprintf(`
+			SELECT id, name, birth_date, gender, %s AS group_id, major, course_year, %s AS status, deleted_at, word_similarity($%d, name) AS similarity
+			FROM students
+			WHERE ($%d <%% name OR name ILIKE '%%' || $%d || '%%')
+		`, group, status, argID, argID, argID)
+		keys = []sortKey{{expr: "t.similarity", typ: "real", desc: true}, idKey}
+		args = append(args, *filter.Query)
+		argID++
+	}
+
+	if !filter.IncludeDeleted {
+		query += " AND deleted_at IS NULL"
+	}
+
+	if len(filter.Statuses) > 0 {
+		query += fmt.Sprintf(" AND %s = ANY($%d)", status, argID)
+		args = append(args, filter.Statuses)
+		argID++
+	}
+
 	if filter.GroupID != nil {
-		query += fmt.Sprintf(" AND group_id = $%d", argID)
+		query += fmt.Sprintf(" AND %s = $%d", group, argID)
 		args = append(args, *filter.GroupID)
 		argID++
 	}
@@ -34,52 +158,56 @@ func (r *Repository) GetAllStudents(ctx context.Context, filter models.StudentFi
 		argID++
 	}
 
-	query += " ORDER BY id"
-
-	if filter.Limit > 0 {
-		query += fmt.Sprintf(" LIMIT $%d", argID)
-		args = append(args, filter.Limit)
+	if filter.TeacherID != nil {
+		query += fmt.Sprintf(" AND %s IN (SELECT group_id FROM teaching_assignments WHERE teacher_id = $%d)", group, argID)
+		args = append(args, *filter.TeacherID)
 		argID++
 	}
 
-	if filter.Offset > 0 {
-		query += fmt.Sprintf(" OFFSET $%d", argID)
-		args = append(args, filter.Offset)
-		argID++
+	keys, err := studentSortColumns.keys(filter.Sort, keys)
+	if err != nil {
+		return listQuery{}, err
 	}
 
-	rows, err := r.db.Query(ctx, query, args...)
+	return listQuery{base: query, args: args, columns: studentColumns, keys: keys}, nil
+}
+
+// SuggestStudents returns up to limit students whose names best match q,
+// optionally only from the groups teacherID teaches.
+func (r *Repository) SuggestStudents(ctx context.Context, q string, teacherID *int, limit int) ([]models.StudentSuggestion, error) {
+	query := `
+		SELECT id, name, group_id
+		FROM students
+		WHERE ($1 <% name OR name ILIKE '%' || $1 || '%')
+			AND deleted_at IS NULL
+			AND ($2::int IS NULL OR group_id IN (SELECT group_id FROM teaching_assignments WHERE teacher_id = $2))
+		ORDER BY word_similarity($1, name) DESC, name
+		LIMIT $3
+	`
+
+	rows, err := r.db.Query(ctx, query, q, teacherID, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var students []models.Student
+	suggestions := []models.StudentSuggestion{}
 	for rows.Next() {
-		var s models.Student
-		err := rows.Scan(
-			&s.ID,
-			&s.Name,
-			&s.BirthDate,
-			&s.Gender,
-			&s.GroupID,
-			&s.Major,
-			&s.CourseYear,
-		)
-		if err != nil {
+		var s models.StudentSuggestion
+		if err := rows.Scan(&s.ID, &s.Name, &s.GroupID); err != nil {
 			return nil, err
 		}
-		students = append(students, s)
+		suggestions = append(suggestions, s)
 	}
 
-	return students, rows.Err()
+	return suggestions, rows.Err()
 }
 
 func (r *Repository) GetStudentByID(ctx context.Context, id int) (*models.Student, error) {
 	query := `
-		SELECT id, name, birth_date, gender, group_id, major, course_year
+		SELECT id, name, birth_date, gender, group_id, major, course_year, status
 		FROM students
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 	row := r.db.QueryRow(ctx, query, id)
 
@@ -92,6 +220,7 @@ func (r *Repository) GetStudentByID(ctx context.Context, id int) (*models.Studen
 		&s.GroupID,
 		&s.Major,
 		&s.CourseYear,
+		&s.Status,
 	)
 	if err != nil {
 		return nil, err
@@ -100,31 +229,229 @@ func (r *Repository) GetStudentByID(ctx context.Context, id int) (*models.Studen
 }
 
 func (r *Repository) CreateStudent(ctx context.Context, s *models.Student) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
 	query := `
-		INSERT INTO students (name, birth_date, gender, group_id, major, course_year)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO students (name, birth_date, gender, group_id, major, course_year, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id
 	`
 	var id int
-	err := r.db.QueryRow(ctx, query,
-		s.Name, s.BirthDate, s.Gender, s.GroupID, s.Major, s.CourseYear,
+	err = tx.QueryRow(ctx, query,
+		s.Name, s.BirthDate, s.Gender, s.GroupID, s.Major, s.CourseYear, s.Status,
 	).Scan(&id)
-	return id, err
+	if err != nil {
+		return 0, err
+	}
+
+	if err := addMembership(ctx, tx, id, s.GroupID); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit(ctx)
 }
 
+// UpdateStudent saves s, recording a change of group as a transfer effective
+// today. It returns pgx.ErrNoRows if there is no such student or it is
+// archived.
 func (r *Repository) UpdateStudent(ctx context.Context, s *models.Student) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var oldGroupID int
+	err = tx.QueryRow(ctx, "SELECT group_id FROM students WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", s.ID).Scan(&oldGroupID)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE students 
 		SET name = $1, birth_date = $2, gender = $3, group_id = $4, major = $5, course_year = $6
 		WHERE id = $7
 	`
-	_, err := r.db.Exec(ctx, query,
+	_, err = tx.Exec(ctx, query,
 		s.Name, s.BirthDate, s.Gender, s.GroupID, s.Major, s.CourseYear, s.ID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if err := syncMembership(ctx, tx, s.ID, oldGroupID, s.GroupID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetExistingStudentIDs returns which of ids belong to a student.
+func (r *Repository) GetExistingStudentIDs(ctx context.Context, ids []int) (map[int]bool, error) {
+	rows, err := r.db.Query(ctx, `SELECT id FROM students WHERE id = ANY($1) AND deleted_at IS NULL`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		existing[id] = true
+	}
+	return existing, rows.Err()
+}
+
+// GetStudentsByNameAndBirthDate returns the students whose name and birth
+// date match one of the given pairs.
+func (r *Repository) GetStudentsByNameAndBirthDate(ctx context.Context, names []string, birthDates []time.Time) ([]models.Student, error) {
+	query := `
+		SELECT id, name, birth_date, gender, group_id, major, course_year, status
+		FROM students
+		WHERE (name, birth_date) IN (SELECT * FROM unnest($1::text[], $2::date[]))
+			AND deleted_at IS NULL
+	`
+	rows, err := r.db.Query(ctx, query, names, birthDates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var students []models.Student
+	for rows.Next() {
+		var s models.Student
+		if err := rows.Scan(&s.ID, &s.Name, &s.BirthDate, &s.Gender, &s.GroupID, &s.Major, &s.CourseYear, &s.Status); err != nil {
+			return nil, err
+		}
+		students = append(students, s)
+	}
+	return students, rows.Err()
+}
+
+// MissingStudentError is returned by ImportStudents when a student to update
+// is gone, archived or deleted since the import was checked.
+type MissingStudentError struct {
+	// Index is the position of the student in the slice given.
+	Index int
+	ID    int
+}
+
+func (e *MissingStudentError) Error() string {
+	return fmt.Sprintf("student %d does not exist", e.ID)
+}
+
+// ImportStudents updates the students that have an id and creates the rest,
+// setting their ids, all or nothing. Changes of group are recorded as
+// transfers effective today. A student to update that is missing or archived
+// fails the import with a *MissingStudentError.
+func (r *Repository) ImportStudents(ctx context.Context, students []models.Student) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for i := range students {
+		s := &students[i]
+		if s.ID != 0 {
+			var oldGroupID int
+			err = tx.QueryRow(ctx, "SELECT group_id FROM students WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", s.ID).Scan(&oldGroupID)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return &MissingStudentError{Index: i, ID: s.ID}
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx, `
+				UPDATE students
+				SET name = $1, birth_date = $2, gender = $3, group_id = $4, major = $5, course_year = $6
+				WHERE id = $7
+			`, s.Name, s.BirthDate, s.Gender, s.GroupID, s.Major, s.CourseYear, s.ID)
+			if err != nil {
+				return err
+			}
+			err = syncMembership(ctx, tx, s.ID, oldGroupID, s.GroupID)
+		} else {
+			err = tx.QueryRow(ctx, `
+				INSERT INTO students (name, birth_date, gender, group_id, major, course_year)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				RETURNING id
+			`, s.Name, s.BirthDate, s.Gender, s.GroupID, s.Major, s.CourseYear).Scan(&s.ID)
+			if err != nil {
+				return err
+			}
+			err = addMembership(ctx, tx, s.ID, s.GroupID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ArchiveStudent soft-deletes a student, keeping their history. It returns
+// pgx.ErrNoRows if there is no such student or it is already archived.
+func (r *Repository) ArchiveStudent(ctx context.Context, id int) error {
+	tag, err := r.db.Exec(ctx, "UPDATE students SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// RestoreStudent brings back an archived student. It returns pgx.ErrNoRows if
+// there is no archived student with that id.
+func (r *Repository) RestoreStudent(ctx context.Context, id int) error {
+	tag, err := r.db.Exec(ctx, "UPDATE students SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// IsStudentArchived reports whether the student is archived. It returns
+// pgx.ErrNoRows if there is no such student.
+func (r *Repository) IsStudentArchived(ctx context.Context, id int) (bool, error) {
+	var archived bool
+	err := r.db.QueryRow(ctx, "SELECT deleted_at IS NOT NULL FROM students WHERE id = $1", id).Scan(&archived)
+	return archived, err
 }
 
-func (r *Repository) DeleteStudent(ctx context.Context, id int) error {
-	_, err := r.db.Exec(ctx, "DELETE FROM students WHERE id = $1", id)
-	return err
+// PurgeStudent deletes an archived student for good along with their grades;
+// attendance and guardian links go with the row. It returns pgx.ErrNoRows if
+// there is no archived student with that id.
+func (r *Repository) PurgeStudent(ctx context.Context, id int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM grades WHERE student_id = $1", id); err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, "DELETE FROM students WHERE id = $1 AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return tx.Commit(ctx)
 }
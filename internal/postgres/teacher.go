@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+func (r *Repository) GetAllTeachers(ctx context.Context) ([]models.Teacher, error) {
+	rows, err := r.db.Query(ctx, `SELECT id, user_id, name FROM teachers ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teachers []models.Teacher
+	for rows.Next() {
+		var t models.Teacher
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name); err != nil {
+			return nil, err
+		}
+		teachers = append(teachers, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range teachers {
+		teachers[i].Classes, err = r.GetTeachingClasses(ctx, teachers[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return teachers, nil
+}
+
+func (r *Repository) GetTeacherByUserID(ctx context.Context, userID int) (*models.Teacher, error) {
+	var t models.Teacher
+	err := r.db.QueryRow(ctx, `SELECT id, user_id, name FROM teachers WHERE user_id = $1`, userID).
+		Scan(&t.ID, &t.UserID, &t.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Classes, err = r.GetTeachingClasses(ctx, t.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *Repository) CreateTeacher(ctx context.Context, t *models.Teacher) (int, error) {
+	query := `
+		INSERT INTO teachers (user_id, name)
+		VALUES ($1, $2)
+		RETURNING id
+	`
+	var id int
+	err := r.db.QueryRow(ctx, query, t.UserID, t.Name).Scan(&id)
+	return id, err
+}
+
+func (r *Repository) GetTeachingClasses(ctx context.Context, teacherID int) ([]models.TeachingClass, error) {
+	query := `
+		SELECT subject_name, group_id
+		FROM teaching_assignments
+		WHERE teacher_id = $1
+		ORDER BY subject_name, group_id
+	`
+	rows, err := r.db.Query(ctx, query, teacherID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	classes := []models.TeachingClass{}
+	for rows.Next() {
+		var c models.TeachingClass
+		if err := rows.Scan(&c.SubjectName, &c.GroupID); err != nil {
+			return nil, err
+		}
+		classes = append(classes, c)
+	}
+	return classes, rows.Err()
+}
+
+func (r *Repository) AddTeachingClass(ctx context.Context, teacherID int, class models.TeachingClass) error {
+	query := `
+		INSERT INTO teaching_assignments (teacher_id, subject_name, group_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`
+	_, err := r.db.Exec(ctx, query, teacherID, class.SubjectName, class.GroupID)
+	return err
+}
+
+func (r *Repository) RemoveTeachingClass(ctx context.Context, teacherID int, class models.TeachingClass) error {
+	query := `
+		DELETE FROM teaching_assignments
+		WHERE teacher_id = $1 AND subject_name = $2 AND group_id = $3
+	`
+	tag, err := r.db.Exec(ctx, query, teacherID, class.SubjectName, class.GroupID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
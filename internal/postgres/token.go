@@ -0,0 +1,200 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+func (r *Repository) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) (int, error) {
+	query := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	var id int
+	err := r.db.QueryRow(ctx, query, t.UserID, t.FamilyID, t.TokenHash, t.ExpiresAt).Scan(&id)
+	return id, err
+}
+
+// UseRefreshToken marks a live refresh token as used and returns it. It
+// returns pgx.ErrNoRows when the token is unknown, expired, revoked or was
+// already used, so rotation cannot hand out two successors for one token.
+func (r *Repository) UseRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+		RETURNING id, user_id, family_id, token_hash, expires_at, used_at, revoked_at
+	`
+	return scanRefreshToken(r.db.QueryRow(ctx, query, tokenHash))
+}
+
+func (r *Repository) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	query := `
+		SELECT id, user_id, family_id, token_hash, expires_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+	return scanRefreshToken(r.db.QueryRow(ctx, query, tokenHash))
+}
+
+// RevokeRefreshTokenFamily revokes the refresh tokens of familyID and ends
+// the session they belong to.
+func (r *Repository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = now()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`, familyID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE sessions
+		SET terminated_at = now()
+		WHERE family_id = $1 AND terminated_at IS NULL
+	`, familyID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// RevokeToken puts an access token on the deny list until it expires on its
+// own. Entries that have outlived their token are cleared on the way.
+func (r *Repository) RevokeToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	if _, err := r.db.Exec(ctx, "DELETE FROM revoked_tokens WHERE expires_at < now()"); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING
+	`
+	_, err := r.db.Exec(ctx, query, jti, userID, expiresAt)
+	return err
+}
+
+// IsTokenRevoked reports whether the token was revoked on its own, was issued
+// before all of its user's tokens were revoked, or belongs to a deleted or
+// disabled user.
+func (r *Repository) IsTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error) {
+	query := `
+		SELECT
+			EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR NOT EXISTS (
+				SELECT 1 FROM users
+				WHERE id = $2 AND disabled_at IS NULL
+					AND (tokens_revoked_at IS NULL OR tokens_revoked_at <= $3)
+			)
+	`
+	var revoked bool
+	err := r.db.QueryRow(ctx, query, jti, userID, issuedAt).Scan(&revoked)
+	return revoked, err
+}
+
+// RevokeUserTokens invalidates every access and refresh token issued to the
+// user so far.
+func (r *Repository) RevokeUserTokens(ctx context.Context, userID int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE users
+		SET tokens_revoked_at = now()
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE sessions
+		SET terminated_at = now()
+		WHERE user_id = $1 AND terminated_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// CreateUserToken stores a one-time token for purpose and invalidates any
+// earlier unused token the user had for the same purpose.
+func (r *Repository) CreateUserToken(ctx context.Context, userID int, purpose, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE user_tokens
+		SET used_at = now()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, purpose)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, purpose, tokenHash, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseUserToken consumes a live one-time token and returns the user it was
+// issued to, or pgx.ErrNoRows if there is no such token.
+func (r *Repository) UseUserToken(ctx context.Context, purpose, tokenHash string) (int, error) {
+	query := `
+		UPDATE user_tokens
+		SET used_at = now()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id
+	`
+	var userID int
+	err := r.db.QueryRow(ctx, query, tokenHash, purpose).Scan(&userID)
+	return userID, err
+}
+
+func scanRefreshToken(row pgx.Row) (*models.RefreshToken, error) {
+	var t models.RefreshToken
+	err := row.Scan(&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash, &t.ExpiresAt, &t.UsedAt, &t.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
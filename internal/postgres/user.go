@@ -0,0 +1,183 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const userAccountColumns = `id, email, role, email_verified_at IS NOT NULL, student_id,
+	totp_enabled_at IS NOT NULL, oidc_subject IS NOT NULL, disabled_at IS NOT NULL, created_at`
+
+func scanUserAccount(row pgx.Row) (*models.UserAccount, error) {
+	var u models.UserAccount
+	err := row.Scan(&u.ID, &u.Email, &u.Role, &u.EmailVerified, &u.StudentID,
+		&u.TwoFactorEnabled, &u.SingleSignOn, &u.Disabled, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUsers returns one page of the users matching filter, together with how
+// many match in total.
+func (r *Repository) GetUsers(ctx context.Context, filter models.UserFilter) (*models.UserList, error) {
+	where := " WHERE 1=1"
+	var args []interface{}
+	argID := 1
+
+	if filter.Query != nil {
+		where += fmt.Sprintf(" AND email ILIKE $%d", argID)
+		args = append(args, "%"+*filter.Query+"%")
+		argID++
+	}
+
+	if filter.Role != nil {
+		where += fmt.Sprintf(" AND role = $%d", argID)
+		args = append(args, *filter.Role)
+		argID++
+	}
+
+	if filter.Disabled != nil {
+		where += fmt.Sprintf(" AND (disabled_at IS NOT NULL) = $%d", argID)
+		args = append(args, *filter.Disabled)
+		argID++
+	}
+
+	list := &models.UserList{Users: []models.UserAccount{}}
+	if err := r.db.QueryRow(ctx, "SELECT count(*) FROM users"+where, args...).Scan(&list.Total); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + userAccountColumns + " FROM users" + where + " ORDER BY id"
+
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argID)
+		args = append(args, filter.Limit)
+		argID++
+	}
+
+	if filter.Offset > 0 {
+		query += fmt.Sprintf(" OFFSET $%d", argID)
+		args = append(args, filter.Offset)
+		argID++
+	}
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUserAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		list.Users = append(list.Users, *u)
+	}
+
+	return list, rows.Err()
+}
+
+func (r *Repository) GetUserAccount(ctx context.Context, id int) (*models.UserAccount, error) {
+	query := "SELECT " + userAccountColumns + " FROM users WHERE id = $1"
+	return scanUserAccount(r.db.QueryRow(ctx, query, id))
+}
+
+// SetUserDisabled disables or re-enables the user. It returns pgx.ErrNoRows
+// if the user does not exist.
+func (r *Repository) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	query := `
+		UPDATE users
+		SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, now()) END
+		WHERE id = $1
+	`
+	tag, err := r.db.Exec(ctx, query, id, disabled)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ClearUserPassword removes the user's password, so they can only log in
+// again after a reset or through single sign-on.
+func (r *Repository) ClearUserPassword(ctx context.Context, id int) error {
+	_, err := r.db.Exec(ctx, "UPDATE users SET password_hash = NULL WHERE id = $1", id)
+	return err
+}
+
+func (r *Repository) DeleteUser(ctx context.Context, id int) error {
+	tag, err := r.db.Exec(ctx, "DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeAPIKeysExceptRole revokes the user's keys that act with a role other
+// than role.
+func (r *Repository) RevokeAPIKeysExceptRole(ctx context.Context, userID int, role string) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = now()
+		WHERE user_id = $1 AND role <> $2 AND revoked_at IS NULL
+	`
+	_, err := r.db.Exec(ctx, query, userID, role)
+	return err
+}
+
+func (r *Repository) CreateAuditEntry(ctx context.Context, e *models.AuditEntry) error {
+	details := []byte("{}")
+	if e.Details != nil {
+		var err error
+		if details, err = json.Marshal(e.Details); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO admin_audit_log (admin_id, action, target_user_id, details)
+		VALUES ($1, $2, $3, $4::jsonb)
+	`
+	_, err := r.db.Exec(ctx, query, e.AdminID, e.Action, e.TargetUserID, string(details))
+	return err
+}
+
+// GetAuditLog lists audit entries newest first, only those about
+// targetUserID when it is given.
+func (r *Repository) GetAuditLog(ctx context.Context, targetUserID *int, limit, offset int) ([]models.AuditEntry, error) {
+	query := `
+		SELECT id, admin_id, action, target_user_id, details, created_at
+		FROM admin_audit_log
+		WHERE $1::int IS NULL OR target_user_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Query(ctx, query, targetUserID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		if err := rows.Scan(&e.ID, &e.AdminID, &e.Action, &e.TargetUserID, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const apiKeyPrefix = "dk_"
+
+var (
+	ErrInvalidAPIKey     = errors.New("invalid api key")
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrInvalidAPIKeyData = errors.New("api key needs a name and at least one known scope")
+	ErrForbidden         = errors.New("forbidden")
+)
+
+// CreateAPIKey issues a key for the user and returns it together with the
+// plain key, which is not stored and cannot be shown again. Keys default to
+// the user's own role; only admins may bind a key to a different role.
+func (s *Service) CreateAPIKey(ctx context.Context, userID int, name, role string, scopes []string) (*models.APIKey, string, error) {
+	if name == "" || len(scopes) == 0 {
+		return nil, "", ErrInvalidAPIKeyData
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(models.APIKeyScopes, scope) {
+			return nil, "", ErrInvalidAPIKeyData
+		}
+	}
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if role == "" {
+		role = user.Role
+	}
+	if !ValidRole(role) {
+		return nil, "", ErrUnknownRole
+	}
+	if role != user.Role && user.Role != models.RoleAdmin {
+		return nil, "", ErrForbidden
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	plain := apiKeyPrefix + secret
+
+	key := &models.APIKey{
+		UserID:  userID,
+		Name:    name,
+		Prefix:  plain[:len(apiKeyPrefix)+8],
+		KeyHash: hashToken(plain),
+		Role:    role,
+		Scopes:  slices.Compact(slices.Sorted(slices.Values(scopes))),
+	}
+	if _, err := s.repo.CreateAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+
+	return key, plain, nil
+}
+
+func (s *Service) GetAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	return s.repo.GetAPIKeysByUserID(ctx, userID)
+}
+
+func (s *Service) RevokeAPIKey(ctx context.Context, userID, id int) error {
+	err := s.repo.RevokeAPIKey(ctx, userID, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
+
+// AuthenticateAPIKey resolves a plain key sent by a client and marks it used.
+func (s *Service) AuthenticateAPIKey(ctx context.Context, plain string) (*models.APIKey, error) {
+	key, err := s.repo.UseAPIKey(ctx, hashToken(plain))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidAPIKey
+	}
+	return key, err
+}
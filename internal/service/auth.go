@@ -2,50 +2,147 @@ package service
 
 import (
 	"context"
-	"os"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/ansarctica/domashka4/internal/models"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUnknownRole  = errors.New("unknown role")
+)
+
 type TokenClaims struct {
 	jwt.RegisteredClaims
-	UserID int `json:"user_id"`
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
+	// TwoFactor is set on tokens of users who passed a second factor.
+	TwoFactor bool `json:"tfa,omitempty"`
+	// SessionID is the login session the token was issued in.
+	SessionID int `json:"sid,omitempty"`
+	// Purpose marks tokens that are not access tokens, such as login
+	// challenges. ParseToken refuses them.
+	Purpose string `json:"purpose,omitempty"`
+	// IssuedAtMicro is the issue time in microseconds. iat only has whole
+	// seconds, too coarse to tell tokens issued just before a revocation
+	// from those issued just after.
+	IssuedAtMicro int64 `json:"iat_us,omitempty"`
+}
+
+// issuedAt is when the token was issued, as precisely as it records it.
+func (c *TokenClaims) issuedAt() time.Time {
+	if c.IssuedAtMicro != 0 {
+		return time.UnixMicro(c.IssuedAtMicro)
+	}
+	return c.IssuedAt.Time
+}
+
+func ValidRole(role string) bool {
+	switch role {
+	case models.RoleAdmin, models.RoleTeacher, models.RoleStudent, models.RoleParent:
+		return true
+	}
+	return false
 }
 
 func (s *Service) CreateUser(ctx context.Context, user *models.User) (int, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	user.Email = normalizeEmail(user.Email)
+	if user.Role == "" {
+		user.Role = models.RoleStudent
+	}
+	if !ValidRole(user.Role) {
+		return 0, ErrUnknownRole
+	}
+	if err := s.policy.Check(user.Password); err != nil {
+		return 0, err
+	}
+
+	hash, err := hashPassword(user.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	user.Password = hash
+	id, err := s.repo.CreateUser(ctx, user)
 	if err != nil {
 		return 0, err
 	}
 
-	user.Password = string(hash)
-	return s.repo.CreateUser(ctx, user)
+	if !user.EmailVerified {
+		if err := s.sendVerificationEmail(ctx, id, user.Email); err != nil {
+			return 0, err
+		}
+	}
+
+	return id, nil
 }
 
-func (s *Service) GenerateToken(ctx context.Context, email, password string) (string, error) {
-	user, err := s.repo.GetUserByEmail(ctx, email)
+// normalizeEmail is the form emails are stored and looked up in, so the
+// case an address is typed in does not matter.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return "", err
 	}
+	return string(hash), nil
+}
+
+// GenerateToken logs a user in. Every wrong email or password is reported as
+// ErrInvalidCredentials and counted towards a lockout of the email and of ip.
+func (s *Service) GenerateToken(ctx context.Context, email, password, ip, userAgent string) (*models.AuthTokens, error) {
+	email = normalizeEmail(email)
+	if err := s.checkLock(ctx, loginLockKeys(email, ip)); err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, s.loginFailed(ctx, email, ip)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", err
+		return nil, s.loginFailed(ctx, email, ip)
+	}
+
+	if err := s.repo.ClearLoginAttempts(ctx, []string{emailLockKey(email)}); err != nil {
+		return nil, err
+	}
+
+	if !user.EmailVerified {
+		return nil, ErrEmailNotVerified
 	}
 
-	claims := TokenClaims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt: jwt.NewNumericDate(time.Now()),
-		},
-		UserID: user.ID,
+	if user.TwoFactorEnabled {
+		return s.issueChallenge(user)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("SECRET_KEY")))
+	return s.startSession(ctx, user, ip, userAgent)
+}
+
+func (s *Service) loginFailed(ctx context.Context, email, ip string) error {
+	if err := s.recordFailure(ctx, loginLockKeys(email, ip)); err != nil {
+		return err
+	}
+	return ErrInvalidCredentials
 }
 
 func (s *Service) GetUserByID(ctx context.Context, id int) (*models.User, error) {
-	return s.repo.GetUserByID(ctx, id)
+	user, err := s.repo.GetUserByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
 }
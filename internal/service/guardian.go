@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const guardianInvitationTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidInvitation    = errors.New("invalid or expired invitation")
+	ErrInvitationRecipient  = errors.New("invitation needs an email and at least one existing student")
+	ErrNotGuardianAccount   = errors.New("this email belongs to an account that cannot be a guardian")
+	ErrPasswordRequired     = errors.New("password is required to create the guardian account")
+	errNotGuardianOfStudent = fmt.Errorf("%w: not your student", ErrForbidden)
+)
+
+// InviteGuardian emails a one-time link that lets the owner of email follow
+// the given students read-only.
+func (s *Service) InviteGuardian(ctx context.Context, who models.Identity, email string, studentIDs []int) (*models.GuardianInvitation, error) {
+	if email == "" || len(studentIDs) == 0 {
+		return nil, ErrInvitationRecipient
+	}
+
+	count, err := s.repo.CountStudents(ctx, studentIDs)
+	if err != nil {
+		return nil, err
+	}
+	if count != len(studentIDs) {
+		return nil, ErrInvitationRecipient
+	}
+
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &models.GuardianInvitation{
+		Email:      normalizeEmail(email),
+		StudentIDs: studentIDs,
+		TokenHash:  hash,
+		InvitedBy:  &who.UserID,
+		ExpiresAt:  time.Now().Add(guardianInvitationTTL),
+	}
+	if _, err := s.repo.CreateGuardianInvitation(ctx, inv); err != nil {
+		return nil, err
+	}
+
+	body := fmt.Sprintf(
+		"You have been invited to follow your child's grades, attendance and schedule.\n\n"+
+			"Open this link within %s to accept the invitation:\n%s",
+		guardianInvitationTTL, s.links.appLink("/guardian/accept", token),
+	)
+	s.sendMailAsync(inv.Email, "Guardian invitation", body)
+
+	return inv, nil
+}
+
+// AcceptGuardianInvitation links the invited students to the guardian account
+// of the invited email, creating that account with password if there is
+// none yet. Following the emailed link proves the address, so new accounts
+// start out verified.
+func (s *Service) AcceptGuardianInvitation(ctx context.Context, token, password string) error {
+	inv, err := s.repo.GetPendingGuardianInvitation(ctx, hashToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidInvitation
+	}
+	if err != nil {
+		return err
+	}
+
+	var userID int
+	user, err := s.repo.GetUserByEmail(ctx, inv.Email)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		if password == "" {
+			return ErrPasswordRequired
+		}
+		userID, err = s.CreateUser(ctx, &models.User{
+			Email:         inv.Email,
+			Password:      password,
+			Role:          models.RoleParent,
+			EmailVerified: true,
+		})
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case user.Role != models.RoleParent:
+		return ErrNotGuardianAccount
+	default:
+		userID = user.ID
+	}
+
+	err = s.repo.AcceptGuardianInvitation(ctx, inv.ID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidInvitation
+	}
+	return err
+}
+
+func (s *Service) GetGuardianStudents(ctx context.Context, userID int) ([]models.Student, error) {
+	return s.repo.GetStudentsByGuardianID(ctx, userID)
+}
+
+// checkStudentRead lets guardians read only the students linked to them.
+// Every other role that reaches a student read route may read any student.
+func (s *Service) checkStudentRead(ctx context.Context, who models.Identity, studentID int) error {
+	if who.Role != models.RoleParent {
+		return nil
+	}
+
+	ok, err := s.repo.IsGuardianOf(ctx, who.UserID, studentID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotGuardianOfStudent
+	}
+	return nil
+}
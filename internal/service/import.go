@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/postgres"
+)
+
+const maxImportRows = 5000
+
+var (
+	ErrImportFile    = errors.New("import file is not a table of students")
+	ErrImportInvalid = errors.New("some rows are invalid, nothing was imported")
+)
+
+var (
+	importColumns         = []string{"id", "name", "birth_date", "gender", "group_id", "major", "course_year"}
+	requiredImportColumns = []string{"name", "birth_date", "gender", "group_id", "course_year"}
+)
+
+// importedStudent is a valid row of an import file and the index of its
+// report.
+type importedStudent struct {
+	row     int
+	student models.Student
+}
+
+// ImportStudents creates or updates the students in rows, a header row
+// naming the columns followed by one row per student. Rows are matched to
+// existing students by id or else by name and birth date, so importing the
+// same file twice updates rather than duplicates. Nothing is written in a dry
+// run or when any row is invalid, in which case ErrImportInvalid is returned
+// along with the report.
+func (s *Service) ImportStudents(ctx context.Context, rows [][]string, dryRun bool) (*models.StudentImport, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: the file is empty", ErrImportFile)
+	}
+	if len(rows)-1 > maxImportRows {
+		return nil, fmt.Errorf("%w: at most %d students can be imported at once", ErrImportFile, maxImportRows)
+	}
+
+	columns, err := importHeader(rows[0])
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := s.repo.GetAllGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groupIDs := map[int]bool{}
+	for _, g := range groups {
+		groupIDs[g.ID] = true
+	}
+
+	report := &models.StudentImport{DryRun: dryRun, Rows: []models.StudentImportRow{}}
+	var valid []importedStudent
+	for i, row := range rows[1:] {
+		if isBlankRow(row) {
+			continue
+		}
+
+		cell := func(column string) string {
+			j, ok := columns[column]
+			if !ok || j >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[j])
+		}
+
+		student, errs := parseImportRow(cell, groupIDs)
+		report.Rows = append(report.Rows, models.StudentImportRow{Row: i + 2, Errors: errs})
+		if len(errs) == 0 {
+			valid = append(valid, importedStudent{row: len(report.Rows) - 1, student: student})
+		}
+	}
+
+	if err := s.matchImportedStudents(ctx, report, valid); err != nil {
+		return nil, err
+	}
+
+	for i := range report.Rows {
+		r := &report.Rows[i]
+		switch {
+		case len(r.Errors) > 0:
+			r.Action = ""
+			report.Invalid++
+		case r.Action == models.ImportActionCreate:
+			report.Created++
+		default:
+			report.Updated++
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	if report.Invalid > 0 {
+		return report, ErrImportInvalid
+	}
+
+	students := make([]models.Student, len(valid))
+	for i, v := range valid {
+		students[i] = v.student
+	}
+	err = s.repo.ImportStudents(ctx, students)
+	var missing *postgres.MissingStudentError
+	if errors.As(err, &missing) {
+		// The student was archived or deleted after the rows were matched.
+		r := &report.Rows[valid[missing.Index].row]
+		r.Action = ""
+		r.Errors = append(r.Errors, fmt.Sprintf("id: %v", missing))
+		report.Updated--
+		report.Invalid++
+		return report, ErrImportInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range valid {
+		report.Rows[v.row].StudentID = &students[i].ID
+	}
+	return report, nil
+}
+
+// importHeader maps the column names in header to their positions.
+func importHeader(header []string) (map[string]int, error) {
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(importColumns, name) {
+			return nil, fmt.Errorf("%w: unknown column %q, expected some of %s", ErrImportFile, name, strings.Join(importColumns, ", "))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("%w: column %q appears twice", ErrImportFile, name)
+		}
+		columns[name] = i
+	}
+
+	for _, name := range requiredImportColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: missing column %q", ErrImportFile, name)
+		}
+	}
+	return columns, nil
+}
+
+func parseImportRow(cell func(string) string, groupIDs map[int]bool) (models.Student, []string) {
+	var student models.Student
+	var errs []string
+	fail := func(column, format string, args ...interface{}) {
+		errs = append(errs, column+": "+fmt.Sprintf(format, args...))
+	}
+
+	if v := cell("id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			fail("id", "%q is not a student id", v)
+		}
+		student.ID = id
+	}
+
+	student.Name = cell("name")
+	switch {
+	case student.Name == "":
+		fail("name", "is required")
+	case len([]rune(student.Name)) > 100:
+		fail("name", "is longer than 100 characters")
+	}
+
+	if v := cell("birth_date"); v == "" {
+		fail("birth_date", "is required")
+	} else if date, err := time.Parse(time.DateOnly, v); err != nil {
+		fail("birth_date", "%q is not a date in YYYY-MM-DD format", v)
+	} else if date.After(time.Now()) {
+		fail("birth_date", "%s is in the future", v)
+	} else {
+		student.BirthDate = date
+	}
+
+	student.Gender = strings.ToUpper(cell("gender"))
+	if student.Gender != "M" && student.Gender != "F" {
+		fail("gender", "must be M or F")
+	}
+
+	if v := cell("group_id"); v == "" {
+		fail("group_id", "is required")
+	} else if id, err := strconv.Atoi(v); err != nil {
+		fail("group_id", "%q is not a group id", v)
+	} else if !groupIDs[id] {
+		fail("group_id", "group %d does not exist", id)
+	} else {
+		student.GroupID = id
+	}
+
+	student.Major = cell("major")
+	if len([]rune(student.Major)) > 100 {
+		fail("major", "is longer than 100 characters")
+	}
+
+	if v := cell("course_year"); v == "" {
+		fail("course_year", "is required")
+	} else if year, err := strconv.Atoi(v); err != nil || year < 1 {
+		fail("course_year", "%q is not a course year", v)
+	} else {
+		student.CourseYear = year
+	}
+
+	return student, errs
+}
+
+// matchImportedStudents decides whether each valid row creates or updates a
+// student, flagging rows that name missing students, match several, or
+// repeat an earlier row.
+func (s *Service) matchImportedStudents(ctx context.Context, report *models.StudentImport, valid []importedStudent) error {
+	type nameKey struct {
+		name      string
+		birthDate string
+	}
+	keyOf := func(st models.Student) nameKey {
+		return nameKey{st.Name, st.BirthDate.Format(time.DateOnly)}
+	}
+
+	var ids []int
+	var names []string
+	var birthDates []time.Time
+	for _, v := range valid {
+		if v.student.ID != 0 {
+			ids = append(ids, v.student.ID)
+		} else {
+			names = append(names, v.student.Name)
+			birthDates = append(birthDates, v.student.BirthDate)
+		}
+	}
+
+	existing, err := s.repo.GetExistingStudentIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	matches, err := s.repo.GetStudentsByNameAndBirthDate(ctx, names, birthDates)
+	if err != nil {
+		return err
+	}
+	byName := map[nameKey][]int{}
+	for _, m := range matches {
+		byName[keyOf(m)] = append(byName[keyOf(m)], m.ID)
+	}
+
+	seenIDs := map[int]int{}
+	seenNames := map[nameKey]int{}
+	for i := range valid {
+		v := &valid[i]
+		r := &report.Rows[v.row]
+		key := keyOf(v.student)
+
+		if v.student.ID == 0 {
+			switch found := byName[key]; len(found) {
+			case 0:
+				r.Action = models.ImportActionCreate
+			case 1:
+				v.student.ID = found[0]
+			default:
+				r.Errors = append(r.Errors, "id: several students have this name and birth date, give the id of the one to update")
+				continue
+			}
+		} else if !existing[v.student.ID] {
+			r.Errors = append(r.Errors, fmt.Sprintf("id: student %d does not exist", v.student.ID))
+			continue
+		}
+
+		if r.Action == "" {
+			r.Action = models.ImportActionUpdate
+			if row, ok := seenIDs[v.student.ID]; ok {
+				r.Errors = append(r.Errors, fmt.Sprintf("id: same student as row %d", row))
+				continue
+			}
+			seenIDs[v.student.ID] = r.Row
+		}
+		if row, ok := seenNames[key]; ok {
+			r.Errors = append(r.Errors, fmt.Sprintf("name: same student as row %d", row))
+			continue
+		}
+		seenNames[key] = r.Row
+	}
+	return nil
+}
+
+func isBlankRow(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
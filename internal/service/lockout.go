@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	accountLockThreshold = 5
+	ipLockThreshold      = 20
+	baseLockDuration     = time.Minute
+	maxLockDuration      = time.Hour
+	failureResetAfter    = 24 * time.Hour
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrTooManyAttempts    = errors.New("too many failed login attempts, try again later")
+)
+
+// dummyPasswordHash is compared against when the email is unknown so that a
+// failed login takes as long whether or not the account exists.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// lockKey is something failed attempts are counted against, such as an
+// account or a client IP, and how many it takes to lock it out.
+type lockKey struct {
+	key       string
+	threshold int
+}
+
+func loginLockKeys(email, ip string) []lockKey {
+	return []lockKey{
+		{emailLockKey(email), accountLockThreshold},
+		{ipLockKey(ip), ipLockThreshold},
+	}
+}
+
+func emailLockKey(email string) string {
+	return "email:" + normalizeEmail(email)
+}
+
+func ipLockKey(ip string) string {
+	return "ip:" + ip
+}
+
+func (s *Service) checkLock(ctx context.Context, keys []lockKey) error {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.key
+	}
+
+	until, err := s.repo.GetLoginLock(ctx, names)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+// recordFailure counts a failed attempt against every key, for a login the
+// email and the client IP. Once a key passes its threshold it is locked out,
+// for twice as long with every further failure.
+func (s *Service) recordFailure(ctx context.Context, keys []lockKey) error {
+	for _, k := range keys {
+		count, err := s.repo.RecordLoginFailure(ctx, k.key, failureResetAfter)
+		if err != nil {
+			return err
+		}
+		if count < k.threshold {
+			continue
+		}
+		if err := s.repo.LockLogin(ctx, k.key, time.Now().Add(lockDuration(count-k.threshold))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lockDuration(excess int) time.Duration {
+	d := baseLockDuration
+	for i := 0; i < excess && d < maxLockDuration; i++ {
+		d *= 2
+	}
+	return min(d, maxLockDuration)
+}
+
+// UnlockUser clears the failed-login lockout of the user's email.
+func (s *Service) UnlockUser(ctx context.Context, userID int) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.repo.ClearLoginAttempts(ctx, []string{emailLockKey(user.Email)})
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrNoLinkedStudent      = errors.New("no student profile is linked to this account")
+	ErrStudentNotFound      = errors.New("student not found")
+	ErrStudentAlreadyLinked = errors.New("student is already linked to another user")
+)
+
+// GetLinkedStudent returns the student record linked to the user.
+func (s *Service) GetLinkedStudent(ctx context.Context, userID int) (*models.Student, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.StudentID == nil {
+		return nil, ErrNoLinkedStudent
+	}
+
+	student, err := s.repo.GetStudentByID(ctx, *user.StudentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoLinkedStudent
+	}
+	return student, err
+}
+
+// LinkStudent links the user to a student record, or unlinks it when
+// studentID is nil. A student can be linked to one user at most.
+func (s *Service) LinkStudent(ctx context.Context, userID int, studentID *int) error {
+	err := s.repo.SetUserStudent(ctx, userID, studentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23503":
+			return ErrStudentNotFound
+		case "23505":
+			return ErrStudentAlreadyLinked
+		}
+	}
+	return err
+}
+
+func (s *Service) GetGrades(ctx context.Context, studentID int) ([]models.StudentGrade, error) {
+	return s.repo.GetGradesByStudentID(ctx, studentID)
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	mailSendTimeout  = 30 * time.Second
+)
+
+var (
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+	ErrWrongPassword     = errors.New("current password is incorrect")
+)
+
+// ForgotPassword emails a password reset link to the account with the given
+// email. It reports success whether or not the account exists, and sends the
+// mail in the background, so callers cannot probe which emails are registered.
+func (s *Service) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.repo.GetUserByEmail(ctx, normalizeEmail(email))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.sendPasswordReset(ctx, user,
+		"Someone asked to reset the password for your account.",
+		"If it was not you, ignore this email.")
+}
+
+// sendPasswordReset emails the user a reset link between intro and outro.
+func (s *Service) sendPasswordReset(ctx context.Context, user *models.User, intro, outro string) error {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.CreateUserToken(ctx, user.ID, models.TokenPurposePasswordReset, hash, time.Now().Add(passwordResetTTL))
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"%s\n\n"+
+			"Open this link within %s to choose a new password:\n%s\n\n"+
+			"%s",
+		intro, passwordResetTTL, s.links.appLink("/reset-password", token), outro,
+	)
+	s.sendMailAsync(user.Email, "Reset your password", body)
+
+	return nil
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword and
+// signs the user out everywhere. Following the emailed link also proves the
+// user owns the address, so it counts as verification.
+func (s *Service) ResetPassword(ctx context.Context, token, password string) error {
+	if err := s.policy.Check(password); err != nil {
+		return err
+	}
+
+	userID, err := s.repo.UseUserToken(ctx, models.TokenPurposePasswordReset, hashToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.UpdateUserPassword(ctx, userID, hash); err != nil {
+		return err
+	}
+
+	if err := s.repo.MarkEmailVerified(ctx, userID); err != nil {
+		return err
+	}
+
+	return s.repo.RevokeUserTokens(ctx, userID)
+}
+
+// ChangePassword replaces the user's password after checking the current one,
+// and signs out every session but sessionID, the one making the change.
+// Wrong current passwords count towards the login lockout.
+func (s *Service) ChangePassword(ctx context.Context, userID, sessionID int, current, password, ip string) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	keys := loginLockKeys(user.Email, ip)
+	if err := s.checkLock(ctx, keys); err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current)); err != nil {
+		if err := s.recordFailure(ctx, keys); err != nil {
+			return err
+		}
+		return ErrWrongPassword
+	}
+
+	if err := s.policy.Check(password); err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.UpdateUserPassword(ctx, userID, hash); err != nil {
+		return err
+	}
+
+	return s.repo.TerminateSessionsExcept(ctx, userID, sessionID)
+}
+
+func (s *Service) sendMailAsync(to, subject, body string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+
+		if err := s.mailer.Send(ctx, to, subject, body); err != nil {
+			log.Printf("sending %q to %s: %v", subject, to, err)
+		}
+	}()
+}
+
+// Links are the base URLs of the links in emails: App of the frontend, API
+// of this API as clients reach it.
+type Links struct {
+	App string
+	API string
+}
+
+// NewLinks checks that both base URLs are absolute, so emails never carry
+// links that lead nowhere.
+func NewLinks(appBaseURL, apiBaseURL string) (*Links, error) {
+	if err := checkBaseURL("app", appBaseURL); err != nil {
+		return nil, err
+	}
+	if err := checkBaseURL("api", apiBaseURL); err != nil {
+		return nil, err
+	}
+	return &Links{
+		App: strings.TrimSuffix(appBaseURL, "/"),
+		API: strings.TrimSuffix(apiBaseURL, "/"),
+	}, nil
+}
+
+func checkBaseURL(name, base string) error {
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%s base url %q is not an absolute url", name, base)
+	}
+	return nil
+}
+
+// appLink builds a link into the frontend carrying token.
+func (l *Links) appLink(path, token string) string {
+	return l.App + path + "?token=" + url.QueryEscape(token)
+}
+
+// apiLink builds a link to this API carrying token.
+func (l *Links) apiLink(path, token string) string {
+	return l.API + path + "?token=" + url.QueryEscape(token)
+}
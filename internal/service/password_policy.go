@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// maxPasswordBytes is as much as bcrypt looks at.
+const maxPasswordBytes = 72
+
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+// PasswordPolicy decides which passwords users may choose.
+type PasswordPolicy struct {
+	MinLength int
+	// MinClasses is how many of lowercase letters, uppercase letters, digits
+	// and other characters a password must mix.
+	MinClasses int
+	blocked    map[string]struct{}
+}
+
+// NewPasswordPolicy builds a policy, loading the passwords nobody may use from
+// blocklistFile, one per line, when it is set. Blocked passwords are matched
+// case-insensitively.
+func NewPasswordPolicy(minLength, minClasses int, blocklistFile string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{
+		MinLength:  minLength,
+		MinClasses: minClasses,
+		blocked:    make(map[string]struct{}),
+	}
+	if blocklistFile == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(blocklistFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			p.blocked[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Check returns an error wrapping ErrWeakPassword that says what is wrong
+// with password, or nil if it may be used.
+func (p *PasswordPolicy) Check(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return fmt.Errorf("%w: it must be at least %d characters long", ErrWeakPassword, p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: it must be at most %d bytes long", ErrWeakPassword, maxPasswordBytes)
+	}
+
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, other} {
+		if has {
+			classes++
+		}
+	}
+	if classes < p.MinClasses {
+		return fmt.Errorf("%w: it must mix at least %d of lowercase letters, uppercase letters, digits and symbols", ErrWeakPassword, p.MinClasses)
+	}
+
+	if _, ok := p.blocked[strings.ToLower(password)]; ok {
+		return fmt.Errorf("%w: it is too common or has appeared in a data breach", ErrWeakPassword)
+	}
+	return nil
+}
@@ -3,114 +3,440 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 
+	"github.com/ansarctica/domashka4/internal/jwtkeys"
+	"github.com/ansarctica/domashka4/internal/mailer"
 	"github.com/ansarctica/domashka4/internal/models"
 	"github.com/ansarctica/domashka4/internal/postgres"
+	"github.com/ansarctica/domashka4/internal/sso"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrInvalidCursor is returned by the list methods for a page cursor that
+	// was tampered with or belongs to another list or sort order.
+	ErrInvalidCursor = postgres.ErrInvalidCursor
+	// ErrInvalidSort is returned for sorting by a column a list does not
+	// allow.
+	ErrInvalidSort = postgres.ErrInvalidSort
 )
 
 type Service struct {
-	repo *postgres.Repository
+	repo   *postgres.Repository
+	mailer mailer.Mailer
+	keys   *jwtkeys.Manager
+	// sso is nil when single sign-on is not configured.
+	sso       *sso.Provider
+	policy    *PasswordPolicy
+	twoFactor *TwoFactorPolicy
+	links     *Links
 }
 
-func NewService(repo *postgres.Repository) *Service {
-	return &Service{repo: repo}
+func NewService(repo *postgres.Repository, mailer mailer.Mailer, keys *jwtkeys.Manager, provider *sso.Provider, policy *PasswordPolicy, twoFactor *TwoFactorPolicy, links *Links) *Service {
+	return &Service{repo: repo, mailer: mailer, keys: keys, sso: provider, policy: policy, twoFactor: twoFactor, links: links}
 }
 
-func (s *Service) GetAllStudents(ctx context.Context, filter models.StudentFilter) ([]models.Student, error) {
+// GetAllStudents lists students. Teachers who do not filter by group only see
+// the groups they teach, and only admins may include archived students.
+func (s *Service) GetAllStudents(ctx context.Context, who models.Identity, filter models.StudentFilter) (*models.Page[models.Student], error) {
+	if filter.IncludeDeleted && who.Role != models.RoleAdmin {
+		return nil, errArchivedStudents
+	}
+	if err := checkStatuses(filter.Statuses); err != nil {
+		return nil, err
+	}
+	if filter.GroupID == nil {
+		teacher, err := s.teacherScope(ctx, who)
+		if err != nil {
+			return nil, err
+		}
+		if teacher != nil {
+			filter.TeacherID = &teacher.ID
+		}
+	}
 	return s.repo.GetAllStudents(ctx, filter)
 }
-func (s *Service) GetStudent(ctx context.Context, id int) (*models.Student, error) {
-	return s.repo.GetStudentByID(ctx, id)
+
+// ExportStudents calls fn with every student GetAllStudents would list for
+// filter, as they are read from the database.
+func (s *Service) ExportStudents(ctx context.Context, who models.Identity, filter models.StudentFilter, columns models.StudentExportColumns, fn func(*models.StudentExport) error) error {
+	if filter.IncludeDeleted && who.Role != models.RoleAdmin {
+		return errArchivedStudents
+	}
+	if err := checkStatuses(filter.Statuses); err != nil {
+		return err
+	}
+	if filter.GroupID == nil {
+		teacher, err := s.teacherScope(ctx, who)
+		if err != nil {
+			return err
+		}
+		if teacher != nil {
+			filter.TeacherID = &teacher.ID
+		}
+	}
+	return s.repo.ExportStudents(ctx, filter, columns, fn)
+}
+
+// SuggestStudents finds students by a possibly misspelt name for
+// autocomplete. Teachers only get students from the groups they teach.
+func (s *Service) SuggestStudents(ctx context.Context, who models.Identity, q string, limit int) ([]models.StudentSuggestion, error) {
+	var teacherID *int
+	teacher, err := s.teacherScope(ctx, who)
+	if err != nil {
+		return nil, err
+	}
+	if teacher != nil {
+		teacherID = &teacher.ID
+	}
+	return s.repo.SuggestStudents(ctx, q, teacherID, limit)
 }
 
+// GetStudent returns a student, or ErrStudentNotFound if there is no such
+// student or it is archived.
+func (s *Service) GetStudent(ctx context.Context, who models.Identity, id int) (*models.Student, error) {
+	if err := s.checkStudentRead(ctx, who, id); err != nil {
+		return nil, err
+	}
+	student, err := s.repo.GetStudentByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrStudentNotFound
+	}
+	return student, err
+}
+
+// CreateStudent adds a student, enrolled unless another status is given.
 func (s *Service) CreateStudent(ctx context.Context, student *models.Student) (int, error) {
+	if student.Status == "" {
+		student.Status = models.StatusEnrolled
+	}
+	if err := checkStatuses([]string{student.Status}); err != nil {
+		return 0, err
+	}
 	return s.repo.CreateStudent(ctx, student)
 }
 
-func (s *Service) UpdateStudent(ctx context.Context, student *models.Student) error {
-	return s.repo.UpdateStudent(ctx, student)
+// UpdateStudent applies patch to a student and saves the result if it is
+// valid. A change of group is recorded as a transfer effective today; the
+// status cannot be patched, see ChangeStudentStatus.
+func (s *Service) UpdateStudent(ctx context.Context, id int, patch func(*models.Student) error) error {
+	student, err := s.repo.GetStudentByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrStudentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	status := student.Status
+	if err := patch(student); err != nil {
+		return err
+	}
+	if student.Status != status {
+		return fmt.Errorf("%w: status is changed with a status change, not an update", ErrInvalidStudent)
+	}
+	student.ID = id
+	student.DeletedAt = nil
+	if err := validateStudent(student); err != nil {
+		return err
+	}
+
+	err = s.repo.UpdateStudent(ctx, student)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrStudentNotFound
+	}
+	if isForeignKeyViolation(err) {
+		return ErrInvalidReference
+	}
+	return err
 }
 
+var (
+	ErrStudentNotArchived = errors.New("only archived students can be purged")
+	errArchivedStudents   = fmt.Errorf("%w: only admins can see archived students", ErrForbidden)
+)
+
+// DeleteStudent archives a student. Archived students drop out of every list
+// and lookup but keep their grades and attendance, and can be restored.
 func (s *Service) DeleteStudent(ctx context.Context, id int) error {
-	return s.repo.DeleteStudent(ctx, id)
+	err := s.repo.ArchiveStudent(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrStudentNotFound
+	}
+	return err
+}
+
+func (s *Service) RestoreStudent(ctx context.Context, id int) error {
+	err := s.repo.RestoreStudent(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrStudentNotFound
+	}
+	return err
+}
+
+// PurgeStudent deletes an archived student for good, with all their grades
+// and attendance. Students have to be archived first.
+func (s *Service) PurgeStudent(ctx context.Context, id int) error {
+	archived, err := s.repo.IsStudentArchived(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrStudentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !archived {
+		return ErrStudentNotArchived
+	}
+
+	err = s.repo.PurgeStudent(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrStudentNotFound
+	}
+	return err
 }
 
 func (s *Service) GetAllGroups(ctx context.Context) ([]models.Group, error) {
 	return s.repo.GetAllGroups(ctx)
 }
 
-func (s *Service) GetSchedules(ctx context.Context, groupID *int) ([]models.Schedule, error) {
-	if groupID != nil {
-		return s.repo.GetGroupScheduleByID(ctx, *groupID)
+// GetSchedules lists schedules. Teachers who do not filter by group only see
+// their own classes.
+func (s *Service) GetSchedules(ctx context.Context, who models.Identity, filter models.ScheduleFilter) (*models.Page[models.Schedule], error) {
+	if filter.GroupID == nil {
+		teacher, err := s.teacherScope(ctx, who)
+		if err != nil {
+			return nil, err
+		}
+		if teacher != nil {
+			filter.TeacherID = &teacher.ID
+		}
 	}
-	return s.repo.GetAllGroupSchedules(ctx)
+	return s.repo.GetSchedules(ctx, filter)
+}
+
+// GetGroupSchedule returns the whole schedule of a group.
+func (s *Service) GetGroupSchedule(ctx context.Context, groupID int) ([]models.Schedule, error) {
+	return s.repo.GetGroupScheduleByID(ctx, groupID)
 }
 
 func (s *Service) CreateSchedule(ctx context.Context, schedule *models.Schedule) (int, error) {
 	return s.repo.CreateSchedule(ctx, schedule)
 }
 
-func (s *Service) UpdateSchedule(ctx context.Context, schedule *models.Schedule) error {
-	return s.repo.UpdateSchedule(ctx, schedule)
+// UpdateSchedule applies patch to a schedule entry and saves the result if
+// it is valid.
+func (s *Service) UpdateSchedule(ctx context.Context, id int, patch func(*models.Schedule) error) error {
+	schedule, err := s.repo.GetScheduleByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrScheduleNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := patch(schedule); err != nil {
+		return err
+	}
+	schedule.ID = id
+	if err := validateSchedule(schedule); err != nil {
+		return err
+	}
+
+	err = s.repo.UpdateSchedule(ctx, schedule)
+	if isForeignKeyViolation(err) {
+		return ErrInvalidReference
+	}
+	return err
 }
 
 func (s *Service) DeleteSchedule(ctx context.Context, id int) error {
 	return s.repo.DeleteSchedule(ctx, id)
 }
-func (s *Service) GetAttendance(ctx context.Context, studentID *int, subjectName *string) ([]models.Attendance, error) {
-	if studentID != nil {
-		return s.repo.GetAttendanceByStudentID(ctx, *studentID)
+
+// GetAttendance lists attendance by student or subject. Teachers may leave
+// both out to get the attendance of their own classes; guardians must ask for
+// one of their students.
+func (s *Service) GetAttendance(ctx context.Context, who models.Identity, filter models.AttendanceFilter) (*models.Page[models.Attendance], error) {
+	if who.Role == models.RoleParent {
+		if filter.StudentID == nil {
+			return nil, errNotGuardianOfStudent
+		}
+		if err := s.checkStudentRead(ctx, who, *filter.StudentID); err != nil {
+			return nil, err
+		}
 	}
-	if subjectName != nil {
-		return s.repo.GetAttendanceBySubjectName(ctx, *subjectName)
+
+	if filter.StudentID == nil && filter.SubjectName == nil {
+		teacher, err := s.teacherScope(ctx, who)
+		if err != nil {
+			return nil, err
+		}
+		if teacher == nil {
+			return nil, errors.New("must provide either student_id or subject_name")
+		}
+		filter.TeacherID = &teacher.ID
 	}
-	return nil, errors.New("must provide either student_id or subject_name")
+	return s.repo.GetAttendance(ctx, filter)
 }
 
-func (s *Service) NewAttendance(ctx context.Context, attendance *models.Attendance) (int, error) {
+// NewAttendance records attendance of an enrolled student. Teachers may only
+// record it for their own subjects in the groups they teach.
+func (s *Service) NewAttendance(ctx context.Context, who models.Identity, attendance *models.Attendance) (int, error) {
+	if err := s.checkAttendanceWrite(ctx, who, attendance); err != nil {
+		return 0, err
+	}
+	if err := s.checkStudentActive(ctx, attendance.StudentID); err != nil {
+		return 0, err
+	}
 	return s.repo.CreateAttendance(ctx, attendance)
 }
 
-func (s *Service) UpdateAttendance(ctx context.Context, attendance *models.Attendance) error {
-	return s.repo.UpdateAttendance(ctx, attendance)
+// UpdateAttendance applies patch to an attendance record and saves the
+// result if it is valid. Teachers may only edit records of their own classes
+// and keep them there.
+func (s *Service) UpdateAttendance(ctx context.Context, who models.Identity, id int, patch func(*models.Attendance) error) error {
+	if err := s.checkAttendanceRecord(ctx, who, id); err != nil {
+		return err
+	}
+
+	attendance, err := s.repo.GetAttendanceByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrAttendanceNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := patch(attendance); err != nil {
+		return err
+	}
+	attendance.ID = id
+	if err := validateAttendance(attendance); err != nil {
+		return err
+	}
+
+	if err := s.checkAttendanceWrite(ctx, who, attendance); err != nil {
+		return err
+	}
+	err = s.repo.UpdateAttendance(ctx, attendance)
+	if isForeignKeyViolation(err) {
+		return ErrInvalidReference
+	}
+	return err
 }
 
-func (s *Service) DeleteAttendance(ctx context.Context, id int) error {
+func (s *Service) DeleteAttendance(ctx context.Context, who models.Identity, id int) error {
+	if err := s.checkAttendanceRecord(ctx, who, id); err != nil {
+		return err
+	}
 	return s.repo.DeleteAttendance(ctx, id)
 }
-func (s *Service) GetAssignments(ctx context.Context, subjectName *string) ([]models.Assignment, error) {
-	return s.repo.GetAssignments(ctx, subjectName)
+
+func (s *Service) checkAttendanceWrite(ctx context.Context, who models.Identity, attendance *models.Attendance) error {
+	teacher, err := s.teacherScope(ctx, who)
+	if err != nil || teacher == nil {
+		return err
+	}
+	return s.checkTeachesStudent(ctx, teacher, attendance.SubjectName, attendance.StudentID)
+}
+
+// checkAttendanceRecord makes sure a teacher only touches attendance that
+// was recorded in one of their classes.
+func (s *Service) checkAttendanceRecord(ctx context.Context, who models.Identity, id int) error {
+	teacher, err := s.teacherScope(ctx, who)
+	if err != nil || teacher == nil {
+		return err
+	}
+
+	existing, err := s.repo.GetAttendanceByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrAttendanceNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.checkTeachesStudent(ctx, teacher, existing.SubjectName, existing.StudentID)
 }
 
-func (s *Service) NewAssignment(ctx context.Context, assignment *models.Assignment) (int, error) {
+// GetAssignments lists assignments. Teachers who do not filter by subject only
+// see assignments in the subjects they teach.
+func (s *Service) GetAssignments(ctx context.Context, who models.Identity, filter models.AssignmentFilter) (*models.Page[models.Assignment], error) {
+	if filter.SubjectName == nil {
+		teacher, err := s.teacherScope(ctx, who)
+		if err != nil {
+			return nil, err
+		}
+		if teacher != nil {
+			filter.TeacherID = &teacher.ID
+		}
+	}
+	return s.repo.GetAssignments(ctx, filter)
+}
+
+// NewAssignment creates an assignment. Teachers may only create them in
+// subjects they teach.
+func (s *Service) NewAssignment(ctx context.Context, who models.Identity, assignment *models.Assignment) (int, error) {
+	teacher, err := s.teacherScope(ctx, who)
+	if err != nil {
+		return 0, err
+	}
+	if teacher != nil && !teachesSubject(teacher, assignment.SubjectName) {
+		return 0, errOutsideTeachingLoad
+	}
 	return s.repo.CreateAssignment(ctx, assignment)
 }
 
-func (s *Service) NewGrade(ctx context.Context, grade *models.Grade) (int, error) {
+// NewGrade records a grade for an enrolled student. Teachers may only grade
+// students of groups they teach the assignment's subject to.
+func (s *Service) NewGrade(ctx context.Context, who models.Identity, grade *models.Grade) (int, error) {
+	teacher, err := s.teacherScope(ctx, who)
+	if err != nil {
+		return 0, err
+	}
+	if teacher != nil {
+		assignment, err := s.repo.GetAssignmentByID(ctx, grade.AssignmentID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrAssignmentNotFound
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := s.checkTeachesStudent(ctx, teacher, assignment.SubjectName, grade.StudentID); err != nil {
+			return 0, err
+		}
+	}
+	if err := s.checkStudentActive(ctx, grade.StudentID); err != nil {
+		return 0, err
+	}
 	return s.repo.CreateGrade(ctx, grade)
 }
 
-func (s *Service) GetGPA(ctx context.Context, studentID int) (float64, error) {
+func (s *Service) GetGPA(ctx context.Context, who models.Identity, studentID int) (float64, error) {
+	if _, err := s.GetStudent(ctx, who, studentID); err != nil {
+		return 0, err
+	}
 	return s.repo.GetGPAByStudentID(ctx, studentID)
 }
-func (s *Service) GetRankings(ctx context.Context, groupID *int, subjectName *string) ([]models.StudentGPA, error) {
 
-	if subjectName != nil {
-		if *subjectName == "all" {
-			subjectName = nil
-		} else if groupID != nil {
-			return s.repo.GetSubjectGPARankingByGroup(ctx, *subjectName, *groupID)
-		}
+// GetStudentSchedule returns the schedule of the student's group.
+func (s *Service) GetStudentSchedule(ctx context.Context, who models.Identity, studentID int) ([]models.Schedule, error) {
+	student, err := s.GetStudent(ctx, who, studentID)
+	if err != nil {
+		return nil, err
 	}
+	return s.repo.GetGroupScheduleByID(ctx, student.GroupID)
+}
+func (s *Service) GetRankings(ctx context.Context, filter models.RankingFilter) (*models.Page[models.StudentGPA], error) {
 
-	if groupID != nil {
-		return s.repo.GetGPARankingByGroup(ctx, *groupID)
-	}
-	if subjectName != nil {
-		return s.repo.GetGPARankingBySubject(ctx, *subjectName)
+	if filter.SubjectName != nil && *filter.SubjectName == "all" {
+		filter.SubjectName = nil
 	}
 
-	return nil, errors.New("must provide group_id, subject_name, or both")
+	if filter.GroupID == nil && filter.SubjectName == nil {
+		return nil, errors.New("must provide group_id, subject_name, or both")
+	}
+	return s.repo.GetGPARanking(ctx, filter)
 }
 
 func (s *Service) GetAllSubjects(ctx context.Context) ([]models.Subject, error) {
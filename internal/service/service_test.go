@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/jwtkeys"
+	"github.com/ansarctica/domashka4/internal/mailer"
+	"github.com/ansarctica/domashka4/internal/postgres"
+	"github.com/ansarctica/domashka4/internal/sso"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestService returns a service backed by the database at
+// TEST_DATABASE_URL, which must have schema.sql loaded. Tests using it are
+// skipped when the variable is not set.
+func newTestService(t *testing.T, provider *sso.Provider) (*Service, *postgres.Repository) {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	keys, err := jwtkeys.NewManager(context.Background(), jwtkeys.Config{Secret: "test-secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewPasswordPolicy(8, 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	twoFactor, err := NewTwoFactorPolicy("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := NewLinks("http://app.test", "http://api.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := postgres.NewRepository(pool)
+	return NewService(repo, mailer.NewLogMailer(""), keys, provider, policy, twoFactor, links), repo
+}
+
+// uniqueEmail returns an address no other test run has used, so tests can
+// share a database.
+func uniqueEmail(t *testing.T) string {
+	name := strings.NewReplacer("/", "-", " ", "-").Replace(strings.ToLower(t.Name()))
+	return fmt.Sprintf("%s-%d@example.com", name, time.Now().UnixNano())
+}
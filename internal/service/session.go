@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// sessionTouchInterval limits how often a session's last-seen time is
+	// written, so that not every request costs an update.
+	sessionTouchInterval = time.Minute
+	maxUserAgentLength   = 512
+)
+
+var (
+	ErrSessionNotFound   = errors.New("session not found")
+	ErrSessionTerminated = errors.New("session has been terminated")
+)
+
+// startSession records a new login from ip and userAgent and issues its
+// first token pair.
+func (s *Service) startSession(ctx context.Context, user *models.User, ip, userAgent string) (*models.AuthTokens, error) {
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	familyID, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	session := &models.Session{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		UserAgent: userAgent,
+		IP:        ip,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	session.ID, err = s.repo.CreateSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, user, session)
+}
+
+func (s *Service) checkSession(ctx context.Context, sessionID, userID int) error {
+	live, lastSeen, err := s.repo.GetSessionActivity(ctx, sessionID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrSessionTerminated
+	}
+	if err != nil {
+		return err
+	}
+	if !live {
+		return ErrSessionTerminated
+	}
+
+	if time.Since(lastSeen) > sessionTouchInterval {
+		return s.repo.TouchSession(ctx, sessionID)
+	}
+	return nil
+}
+
+// GetSessions lists the user's live sessions and marks currentID, the
+// session of the request, as current.
+func (s *Service) GetSessions(ctx context.Context, userID, currentID int) ([]models.Session, error) {
+	sessions, err := s.repo.GetSessionsByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+// TerminateSession signs the user's session out: its refresh tokens stop
+// working at once and so do access tokens issued in it.
+func (s *Service) TerminateSession(ctx context.Context, userID, sessionID int) error {
+	session, err := s.repo.GetSession(ctx, sessionID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.repo.RevokeRefreshTokenFamily(ctx, session.FamilyID)
+}
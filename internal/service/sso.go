@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/oauth2"
+)
+
+const oidcLoginTTL = 10 * time.Minute
+
+var (
+	ErrSSODisabled        = errors.New("single sign-on is not configured")
+	ErrInvalidSSOState    = errors.New("single sign-on attempt is unknown or has expired")
+	ErrSSOFailed          = errors.New("single sign-on failed")
+	ErrSSOEmailUnverified = errors.New("identity provider has not confirmed this email address")
+	ErrSSONoRole          = errors.New("your identity provider account is not allowed to sign in here")
+	ErrSSOAccountConflict = errors.New("this email is already linked to another single sign-on account")
+)
+
+// StartOIDCLogin begins a sign-in at the identity provider and returns the
+// URL to send the user agent to. The state, PKCE verifier and nonce are kept
+// server-side until the provider redirects back.
+func (s *Service) StartOIDCLogin(ctx context.Context) (string, error) {
+	if s.sso == nil {
+		return "", ErrSSODisabled
+	}
+
+	state, stateHash, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	if err := s.repo.CreateOIDCLogin(ctx, stateHash, verifier, nonce, time.Now().Add(oidcLoginTTL)); err != nil {
+		return "", err
+	}
+
+	return s.sso.AuthCodeURL(state, verifier, nonce), nil
+}
+
+// FinishOIDCLogin redeems the code the provider redirected back with and
+// signs the user in. Users are found by provider subject first and then by
+// verified email, which links the existing account; otherwise a new account
+// is created. A role mapped from the provider's claims replaces the stored
+// one on every sign-in and, when it differs, revokes the user's tokens and
+// API keys as an admin's role change does. Users with two-factor
+// authentication still get a challenge, as with a password login.
+func (s *Service) FinishOIDCLogin(ctx context.Context, state, code, ip, userAgent string) (*models.AuthTokens, error) {
+	if s.sso == nil {
+		return nil, ErrSSODisabled
+	}
+
+	verifier, nonce, err := s.repo.UseOIDCLogin(ctx, hashToken(state))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidSSOState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := s.sso.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSSOFailed, err)
+	}
+
+	user, err := s.repo.GetUserByOIDCSubject(ctx, identity.Subject)
+	if errors.Is(err, pgx.ErrNoRows) {
+		user, err = s.linkOIDCUser(ctx, identity.Subject, identity.Email, identity.EmailVerified, identity.Role)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.Role != "" && identity.Role != user.Role {
+		if err := s.setUserRole(ctx, user.ID, identity.Role); err != nil {
+			return nil, err
+		}
+		user.Role = identity.Role
+	}
+
+	if user.TwoFactorEnabled {
+		return s.issueChallenge(user)
+	}
+
+	return s.startSession(ctx, user, ip, userAgent)
+}
+
+// linkOIDCUser attaches subject to the account with email, or creates one.
+func (s *Service) linkOIDCUser(ctx context.Context, subject, email string, emailVerified bool, role string) (*models.User, error) {
+	if email == "" || !emailVerified {
+		return nil, ErrSSOEmailUnverified
+	}
+	email = normalizeEmail(email)
+
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	if err == nil {
+		if user.OIDCSubject != nil {
+			return nil, ErrSSOAccountConflict
+		}
+		if err := s.repo.LinkUserOIDCSubject(ctx, user.ID, subject); err != nil {
+			return nil, err
+		}
+		user.OIDCSubject = &subject
+		user.EmailVerified = true
+		return user, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	if role == "" {
+		role = s.sso.DefaultRole()
+	}
+	if role == "" {
+		return nil, ErrSSONoRole
+	}
+
+	user = &models.User{
+		Email:         email,
+		Role:          role,
+		EmailVerified: true,
+		OIDCSubject:   &subject,
+	}
+	user.ID, err = s.repo.CreateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
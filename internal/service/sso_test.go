@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/sso"
+	"github.com/ansarctica/domashka4/internal/sso/ssotest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestProvider(t *testing.T) (*ssotest.Provider, *sso.Provider) {
+	t.Helper()
+
+	mock := ssotest.NewProvider(t)
+	provider, err := sso.NewProvider(context.Background(), sso.Config{
+		Issuer:      mock.URL,
+		ClientID:    ssotest.ClientID,
+		RedirectURL: "http://localhost/callback",
+		RoleClaim:   "groups",
+		RoleMap:     map[string]string{"staff": models.RoleTeacher, "students": models.RoleStudent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mock, provider
+}
+
+// signIn goes through a whole single sign-on with the given ID token claims.
+func signIn(t *testing.T, s *Service, mock *ssotest.Provider, claims jwt.MapClaims) (*models.AuthTokens, error) {
+	t.Helper()
+
+	ctx := context.Background()
+	authURL, err := s.StartOIDCLogin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := mock.Authorize(authURL, claims)
+	return s.FinishOIDCLogin(ctx, state, code, "127.0.0.1", "test")
+}
+
+func TestOIDCLoginDisabled(t *testing.T) {
+	s := &Service{}
+	if _, err := s.StartOIDCLogin(context.Background()); !errors.Is(err, ErrSSODisabled) {
+		t.Errorf("StartOIDCLogin() error = %v, want ErrSSODisabled", err)
+	}
+	if _, err := s.FinishOIDCLogin(context.Background(), "state", "code", "", ""); !errors.Is(err, ErrSSODisabled) {
+		t.Errorf("FinishOIDCLogin() error = %v, want ErrSSODisabled", err)
+	}
+}
+
+func TestOIDCLoginLinksAccounts(t *testing.T) {
+	mock, provider := newTestProvider(t)
+	s, repo := newTestService(t, provider)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// existing creates an account with the signing-in email first,
+		// linked to linkedTo when that is set.
+		existing bool
+		linkedTo string
+		verified bool
+		groups   any
+		wantErr  error
+		wantRole string
+	}{
+		{name: "links account with verified email", existing: true, verified: true, groups: "students", wantRole: models.RoleStudent},
+		{name: "refuses unverified email", existing: true, verified: false, groups: "students", wantErr: ErrSSOEmailUnverified},
+		{name: "refuses account linked elsewhere", existing: true, linkedTo: "other", verified: true, groups: "students", wantErr: ErrSSOAccountConflict},
+		{name: "creates account with mapped role", verified: true, groups: []any{"students", "staff"}, wantRole: models.RoleTeacher},
+		{name: "refuses new account without role", verified: true, groups: "guests", wantErr: ErrSSONoRole},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := uniqueEmail(t)
+			subject := fmt.Sprintf("sub-%d", time.Now().UnixNano())
+
+			var existingID int
+			if tt.existing {
+				user := &models.User{Email: email, Role: models.RoleStudent}
+				if tt.linkedTo != "" {
+					linked := tt.linkedTo + subject
+					user.OIDCSubject = &linked
+				}
+				var err error
+				if existingID, err = repo.CreateUser(ctx, user); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tokens, err := signIn(t, s, mock, jwt.MapClaims{
+				"sub":            subject,
+				"email":          email,
+				"email_verified": tt.verified,
+				"groups":         tt.groups,
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("FinishOIDCLogin() error = %v, want %v", err, tt.wantErr)
+				}
+				if _, err := repo.GetUserByOIDCSubject(ctx, subject); err == nil {
+					t.Error("subject was linked to an account")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FinishOIDCLogin() error = %v", err)
+			}
+			if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+				t.Errorf("FinishOIDCLogin() = %+v, want a token pair", tokens)
+			}
+
+			user, err := repo.GetUserByOIDCSubject(ctx, subject)
+			if err != nil {
+				t.Fatalf("subject was not linked: %v", err)
+			}
+			if tt.existing && user.ID != existingID {
+				t.Errorf("subject linked to user %d, want %d", user.ID, existingID)
+			}
+			if !user.EmailVerified {
+				t.Error("email is not marked verified")
+			}
+			if user.Role != tt.wantRole {
+				t.Errorf("role = %q, want %q", user.Role, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginMapsRole(t *testing.T) {
+	mock, provider := newTestProvider(t)
+	s, repo := newTestService(t, provider)
+	ctx := context.Background()
+
+	email := uniqueEmail(t)
+	claims := jwt.MapClaims{
+		"sub":            fmt.Sprintf("sub-%d", time.Now().UnixNano()),
+		"email":          email,
+		"email_verified": true,
+	}
+
+	steps := []struct {
+		groups   any
+		wantRole string
+		// revokes is whether the sign-in revokes the tokens of the one
+		// before it.
+		revokes bool
+	}{
+		{groups: "students", wantRole: models.RoleStudent},
+		{groups: "students", wantRole: models.RoleStudent},
+		{groups: "staff", wantRole: models.RoleTeacher, revokes: true},
+		{groups: "guests", wantRole: models.RoleTeacher},
+	}
+
+	var previous *models.AuthTokens
+	for i, step := range steps {
+		claims["groups"] = step.groups
+		tokens, err := signIn(t, s, mock, claims)
+		if err != nil {
+			t.Fatalf("sign-in %d: %v", i, err)
+		}
+
+		user, err := repo.GetUserByEmail(ctx, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != step.wantRole {
+			t.Errorf("sign-in %d: role = %q, want %q", i, user.Role, step.wantRole)
+		}
+
+		if previous != nil {
+			_, err := s.RefreshToken(ctx, previous.RefreshToken)
+			if revoked := errors.Is(err, ErrInvalidRefreshToken); revoked != step.revokes {
+				t.Errorf("sign-in %d: refreshing the previous session gave %v, want revoked = %v", i, err, step.revokes)
+			}
+		}
+		previous = tokens
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/postgres"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrInvalidStatus       = errors.New("unknown student status")
+	ErrInvalidStatusChange = errors.New("invalid status change")
+	ErrStudentInactive     = errors.New("student is not enrolled")
+)
+
+// statusTransitions lists the statuses a student can move to from each
+// status. Graduation is final; expelled and withdrawn students can be
+// readmitted.
+var statusTransitions = map[string][]string{
+	models.StatusApplicant:     {models.StatusEnrolled, models.StatusWithdrawn},
+	models.StatusEnrolled:      {models.StatusAcademicLeave, models.StatusGraduated, models.StatusExpelled, models.StatusWithdrawn},
+	models.StatusAcademicLeave: {models.StatusEnrolled, models.StatusExpelled, models.StatusWithdrawn},
+	models.StatusGraduated:     {},
+	models.StatusExpelled:      {models.StatusEnrolled},
+	models.StatusWithdrawn:     {models.StatusEnrolled},
+}
+
+func checkStatuses(statuses []string) error {
+	for _, status := range statuses {
+		if _, ok := statusTransitions[status]; !ok {
+			return fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+		}
+	}
+	return nil
+}
+
+// checkTransition fails with ErrInvalidStatusChange unless a student can go
+// from one status to the other.
+func checkTransition(from, to string) error {
+	if !slices.Contains(statusTransitions[from], to) {
+		return fmt.Errorf("%w: cannot go from %s to %s", ErrInvalidStatusChange, from, to)
+	}
+	return nil
+}
+
+// ChangeStudentStatus moves a student to another status, if that is one of
+// the statuses their current one allows, from the given day on, or from today
+// if from is nil. Changes need a reason and cannot be dated in the future or
+// before the previous change.
+func (s *Service) ChangeStudentStatus(ctx context.Context, who models.Identity, studentID int, status, reason string, from *time.Time) (*models.StatusChange, error) {
+	day := postgres.Today()
+	if from != nil {
+		day = *from
+	}
+	if err := checkStatuses([]string{status}); err != nil {
+		return nil, err
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, fmt.Errorf("%w: a reason is required", ErrInvalidStatusChange)
+	}
+	if day.After(time.Now()) {
+		return nil, fmt.Errorf("%w: the effective date is in the future", ErrInvalidStatusChange)
+	}
+
+	student, err := s.repo.GetStudentByID(ctx, studentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrStudentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTransition(student.Status, status); err != nil {
+		return nil, err
+	}
+
+	history, err := s.repo.GetStatusChanges(ctx, studentID)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(history); n > 0 && day.Before(history[n-1].EffectiveDate) {
+		return nil, fmt.Errorf("%w: the previous change took effect on %s", ErrInvalidStatusChange, history[n-1].EffectiveDate.Format("02.01.2006"))
+	}
+
+	change := &models.StatusChange{
+		StudentID:     studentID,
+		FromStatus:    student.Status,
+		ToStatus:      status,
+		Reason:        reason,
+		EffectiveDate: day,
+	}
+	if who.UserID != 0 {
+		change.ChangedBy = &who.UserID
+	}
+	err = s.repo.ChangeStudentStatus(ctx, change)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: the student's status was changed meanwhile, try again", ErrInvalidStatusChange)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// GetStatusHistory returns the status changes of a student, earliest first.
+func (s *Service) GetStatusHistory(ctx context.Context, who models.Identity, studentID int) ([]models.StatusChange, error) {
+	if _, err := s.GetStudent(ctx, who, studentID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetStatusChanges(ctx, studentID)
+}
+
+// checkStudentActive fails with ErrStudentInactive unless the student is
+// enrolled.
+func (s *Service) checkStudentActive(ctx context.Context, studentID int) error {
+	student, err := s.repo.GetStudentByID(ctx, studentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrStudentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if student.Status != models.StatusEnrolled {
+		return fmt.Errorf("%w: the student is %s", ErrStudentInactive, student.Status)
+	}
+	return nil
+}
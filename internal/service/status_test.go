@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+)
+
+func TestCheckTransition(t *testing.T) {
+	const (
+		applicant = models.StatusApplicant
+		enrolled  = models.StatusEnrolled
+		leave     = models.StatusAcademicLeave
+		graduated = models.StatusGraduated
+		expelled  = models.StatusExpelled
+		withdrawn = models.StatusWithdrawn
+	)
+
+	tests := []struct {
+		from, to string
+		ok       bool
+	}{
+		{applicant, enrolled, true},
+		{applicant, withdrawn, true},
+		{applicant, graduated, false},
+		{applicant, leave, false},
+		{enrolled, leave, true},
+		{enrolled, graduated, true},
+		{enrolled, expelled, true},
+		{enrolled, withdrawn, true},
+		{enrolled, enrolled, false},
+		{enrolled, applicant, false},
+		{leave, enrolled, true},
+		{leave, expelled, true},
+		{leave, withdrawn, true},
+		{leave, graduated, false},
+		{graduated, enrolled, false},
+		{graduated, withdrawn, false},
+		{expelled, enrolled, true},
+		{expelled, withdrawn, false},
+		{withdrawn, enrolled, true},
+		{withdrawn, applicant, false},
+		{"unknown", enrolled, false},
+		{enrolled, "unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			err := checkTransition(tt.from, tt.to)
+			if tt.ok && err != nil {
+				t.Errorf("checkTransition() error = %v, want nil", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidStatusChange) {
+				t.Errorf("checkTransition() error = %v, want ErrInvalidStatusChange", err)
+			}
+		})
+	}
+}
+
+func TestStatusTransitionsStayKnown(t *testing.T) {
+	for from, next := range statusTransitions {
+		if err := checkStatuses(next); err != nil {
+			t.Errorf("%s leads to an unknown status: %v", from, err)
+		}
+	}
+}
+
+func TestCheckStatuses(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		wantErr  bool
+	}{
+		{"none", nil, false},
+		{"all known", []string{models.StatusEnrolled, models.StatusGraduated}, false},
+		{"one unknown", []string{models.StatusEnrolled, "suspended"}, true},
+		{"wrong case", []string{"Enrolled"}, true},
+		{"empty", []string{""}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStatuses(tt.statuses)
+			if tt.wantErr && !errors.Is(err, ErrInvalidStatus) {
+				t.Errorf("checkStatuses() error = %v, want ErrInvalidStatus", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("checkStatuses() error = %v, want nil", err)
+			}
+		})
+	}
+}
+
+// TestChangeStudentStatusChecksInput covers what is refused before the
+// student is looked up.
+func TestChangeStudentStatusChecksInput(t *testing.T) {
+	tomorrow := time.Now().AddDate(0, 0, 1)
+
+	tests := []struct {
+		name    string
+		status  string
+		reason  string
+		from    *time.Time
+		wantErr error
+	}{
+		{"unknown status", "suspended", "moved away", nil, ErrInvalidStatus},
+		{"no reason", models.StatusWithdrawn, "", nil, ErrInvalidStatusChange},
+		{"blank reason", models.StatusWithdrawn, "  \t", nil, ErrInvalidStatusChange},
+		{"future date", models.StatusWithdrawn, "moved away", &tomorrow, ErrInvalidStatusChange},
+	}
+	s := &Service{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.ChangeStudentStatus(context.Background(), models.Identity{}, 1, tt.status, tt.reason, tt.from)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ChangeStudentStatus() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrNoTeacherProfile   = errors.New("no teacher profile is linked to this account")
+	ErrTeacherNotFound    = errors.New("teacher not found")
+	ErrClassNotFound      = errors.New("teacher does not teach this class")
+	ErrAssignmentNotFound = errors.New("assignment not found")
+	ErrAttendanceNotFound = errors.New("attendance record not found")
+	ErrInvalidReference   = errors.New("unknown user, subject or group")
+
+	errOutsideTeachingLoad = fmt.Errorf("%w: outside of your teaching load", ErrForbidden)
+)
+
+func (s *Service) GetAllTeachers(ctx context.Context) ([]models.Teacher, error) {
+	return s.repo.GetAllTeachers(ctx)
+}
+
+func (s *Service) GetTeacherByUserID(ctx context.Context, userID int) (*models.Teacher, error) {
+	teacher, err := s.repo.GetTeacherByUserID(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoTeacherProfile
+	}
+	return teacher, err
+}
+
+func (s *Service) CreateTeacher(ctx context.Context, teacher *models.Teacher) (int, error) {
+	id, err := s.repo.CreateTeacher(ctx, teacher)
+	if isForeignKeyViolation(err) {
+		return 0, ErrInvalidReference
+	}
+	return id, err
+}
+
+func (s *Service) AddTeachingClass(ctx context.Context, teacherID int, class models.TeachingClass) error {
+	err := s.repo.AddTeachingClass(ctx, teacherID, class)
+	if isForeignKeyViolation(err) {
+		return ErrInvalidReference
+	}
+	return err
+}
+
+func (s *Service) RemoveTeachingClass(ctx context.Context, teacherID int, class models.TeachingClass) error {
+	err := s.repo.RemoveTeachingClass(ctx, teacherID, class)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrClassNotFound
+	}
+	return err
+}
+
+// teacherScope returns the teaching load that limits what the caller may
+// write, or nil if the caller is not a teacher and is not limited by one.
+func (s *Service) teacherScope(ctx context.Context, who models.Identity) (*models.Teacher, error) {
+	if who.Role != models.RoleTeacher {
+		return nil, nil
+	}
+	return s.GetTeacherByUserID(ctx, who.UserID)
+}
+
+func teaches(teacher *models.Teacher, subjectName string, groupID int) bool {
+	for _, c := range teacher.Classes {
+		if c.SubjectName == subjectName && c.GroupID == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+func teachesSubject(teacher *models.Teacher, subjectName string) bool {
+	for _, c := range teacher.Classes {
+		if c.SubjectName == subjectName {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTeachesStudent fails with ErrForbidden unless the teacher teaches
+// subjectName to the group the student belongs to.
+func (s *Service) checkTeachesStudent(ctx context.Context, teacher *models.Teacher, subjectName string, studentID int) error {
+	student, err := s.repo.GetStudentByID(ctx, studentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrStudentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if !teaches(teacher, subjectName, student.GroupID) {
+		return errOutsideTeachingLoad
+	}
+	return nil
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/jwtkeys"
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrInvalidToken        = errors.New("token is not valid")
+)
+
+// ParseToken verifies the signature and expiry of an access token against
+// the key named in its kid header.
+func (s *Service) ParseToken(tokenString string) (*TokenClaims, error) {
+	claims, err := s.parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (s *Service) parseClaims(tokenString string) (*TokenClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, s.keys.Keyfunc,
+		jwt.WithValidMethods(s.keys.Algorithms()),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*TokenClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// JWKS returns the public keys that verify access tokens.
+func (s *Service) JWKS() jwtkeys.JWKSet {
+	return s.keys.JWKS()
+}
+
+// CheckToken consults the revocation store for an access token whose
+// signature and expiry have already been verified.
+func (s *Service) CheckToken(ctx context.Context, claims *TokenClaims) error {
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return ErrTokenRevoked
+	}
+
+	revoked, err := s.repo.IsTokenRevoked(ctx, claims.ID, claims.UserID, claims.issuedAt())
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+
+	if claims.SessionID != 0 {
+		return s.checkSession(ctx, claims.SessionID, claims.UserID)
+	}
+	return nil
+}
+
+// Logout revokes the access token described by claims and, when given, the
+// refresh token family issued alongside it.
+func (s *Service) Logout(ctx context.Context, claims *TokenClaims, refreshToken string) error {
+	if err := s.repo.RevokeToken(ctx, claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+		return err
+	}
+
+	if claims.SessionID != 0 {
+		if err := s.TerminateSession(ctx, claims.UserID, claims.SessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	stored, err := s.repo.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stored.UserID != claims.UserID {
+		return nil
+	}
+	return s.repo.RevokeRefreshTokenFamily(ctx, stored.FamilyID)
+}
+
+func (s *Service) RevokeUserTokens(ctx context.Context, userID int) error {
+	err := s.repo.RevokeUserTokens(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// RefreshToken exchanges a refresh token for a new token pair. Every refresh
+// token can be used once; presenting one that was already rotated means it
+// leaked, so the whole family it belongs to is revoked.
+func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*models.AuthTokens, error) {
+	hash := hashToken(refreshToken)
+
+	current, err := s.repo.UseRefreshToken(ctx, hash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		stored, err := s.repo.GetRefreshTokenByHash(ctx, hash)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return nil, err
+		}
+		if stored.UsedAt != nil && stored.RevokedAt == nil {
+			if err := s.repo.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+				return nil, err
+			}
+			return nil, ErrRefreshTokenReused
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetUserByID(ctx, current.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	session, err := s.repo.GetSessionByFamilyID(ctx, current.FamilyID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ExtendSession(ctx, session.ID, time.Now().Add(refreshTokenTTL)); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, user, session)
+}
+
+// issueTokens signs an access token for user and stores a new refresh token in
+// the session's family.
+func (s *Service) issueTokens(ctx context.Context, user *models.User, session *models.Session) (*models.AuthTokens, error) {
+	accessToken, err := s.signAccessToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, hash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.repo.CreateRefreshToken(ctx, &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  session.FamilyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *Service) signAccessToken(user *models.User, sessionID int) (string, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+		},
+		UserID:        user.ID,
+		Role:          user.Role,
+		TwoFactor:     user.TwoFactorEnabled,
+		SessionID:     sessionID,
+		IssuedAtMicro: now.UnixMicro(),
+	}
+
+	return s.keys.Sign(claims)
+}
+
+// newOpaqueToken returns a random token to hand to the client together with
+// the hash that is stored in its place.
+func newOpaqueToken() (string, string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/jwtkeys"
+	"github.com/ansarctica/domashka4/internal/models"
+)
+
+func TestRefreshTokenReuse(t *testing.T) {
+	s, repo := newTestService(t, nil)
+	ctx := context.Background()
+
+	user := &models.User{Email: uniqueEmail(t), Role: models.RoleStudent}
+	var err error
+	if user.ID, err = repo.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.startSession(ctx, user, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tokens holds every refresh token handed out so far, by step name.
+	tokens := map[string]string{"login": first.RefreshToken, "unknown": "not-a-token"}
+
+	steps := []struct {
+		name string
+		// use is the step whose refresh token is presented.
+		use     string
+		wantErr error
+	}{
+		{name: "rotate", use: "login"},
+		{name: "rotate again", use: "rotate"},
+		{name: "unknown token", use: "unknown", wantErr: ErrInvalidRefreshToken},
+		{name: "replay of a rotated token", use: "login", wantErr: ErrRefreshTokenReused},
+		{name: "latest token after the replay", use: "rotate again", wantErr: ErrInvalidRefreshToken},
+		{name: "replay once the family is revoked", use: "rotate", wantErr: ErrInvalidRefreshToken},
+	}
+	for _, step := range steps {
+		got, err := s.RefreshToken(ctx, tokens[step.use])
+		if step.wantErr != nil {
+			if !errors.Is(err, step.wantErr) {
+				t.Fatalf("%s: RefreshToken() error = %v, want %v", step.name, err, step.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: RefreshToken() error = %v", step.name, err)
+		}
+		if got.RefreshToken == tokens[step.use] {
+			t.Fatalf("%s: refresh token was not rotated", step.name)
+		}
+		tokens[step.name] = got.RefreshToken
+	}
+
+	other, err := s.startSession(ctx, user, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RefreshToken(ctx, other.RefreshToken); err != nil {
+		t.Errorf("a replay revoked another session: %v", err)
+	}
+}
+
+func TestTokenIssuedAt(t *testing.T) {
+	keys, err := jwtkeys.NewManager(context.Background(), jwtkeys.Config{Secret: "test-secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{keys: keys}
+
+	before := time.Now().Truncate(time.Microsecond)
+	token, err := s.signAccessToken(&models.User{ID: 1, Role: models.RoleStudent}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := claims.issuedAt(); got.Before(before) || got.After(time.Now()) {
+		t.Errorf("issuedAt() = %s, want between %s and now", got, before)
+	}
+
+	// Tokens from before the precise claim fall back to iat.
+	claims.IssuedAtMicro = 0
+	if got, want := claims.issuedAt(), claims.IssuedAt.Time; !got.Equal(want) {
+		t.Errorf("issuedAt() without iat_us = %s, want %s", got, want)
+	}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	s, repo := newTestService(t, nil)
+	ctx := context.Background()
+
+	user := &models.User{Email: uniqueEmail(t), Role: models.RoleStudent}
+	var err error
+	if user.ID, err = repo.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	check := func(tokens *models.AuthTokens) error {
+		claims, err := s.ParseToken(tokens.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.CheckToken(ctx, claims)
+	}
+
+	old, err := s.startSession(ctx, user, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeUserTokens(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Signing in again straight away, within the same second.
+	fresh, err := s.startSession(ctx, user, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := check(old); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("token from before the revocation: CheckToken() error = %v, want ErrTokenRevoked", err)
+	}
+	if err := check(fresh); err != nil {
+		t.Errorf("token from after the revocation: CheckToken() error = %v", err)
+	}
+	if _, err := s.RefreshToken(ctx, fresh.RefreshToken); err != nil {
+		t.Errorf("refresh token from after the revocation: RefreshToken() error = %v", err)
+	}
+}
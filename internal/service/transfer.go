@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/postgres"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrInvalidTransfer = errors.New("invalid transfer")
+
+// TransferStudent moves a student to another group from the given day on,
+// or from today if from is nil, ending their current membership that day.
+// Transfers cannot be dated in the future or before the student joined their
+// current group.
+func (s *Service) TransferStudent(ctx context.Context, studentID, groupID int, from *time.Time) error {
+	day := postgres.Today()
+	if from != nil {
+		day = *from
+	}
+
+	current, err := s.repo.GetCurrentGroupMembership(ctx, studentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrStudentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case current.GroupID == groupID:
+		return fmt.Errorf("%w: the student is already in group %d", ErrInvalidTransfer, groupID)
+	case day.After(time.Now()):
+		return fmt.Errorf("%w: the effective date is in the future", ErrInvalidTransfer)
+	case current.ValidFrom != nil && !day.After(*current.ValidFrom):
+		return fmt.Errorf("%w: the student only joined group %d on %s", ErrInvalidTransfer, current.GroupID, current.ValidFrom.Format("02.01.2006"))
+	}
+
+	err = s.repo.TransferStudent(ctx, studentID, groupID, day)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrStudentNotFound
+	}
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("%w: group %d does not exist", ErrInvalidTransfer, groupID)
+	}
+	return err
+}
+
+// GetGroupHistory returns the groups a student has been in, earliest first.
+func (s *Service) GetGroupHistory(ctx context.Context, who models.Identity, studentID int) ([]models.GroupMembership, error) {
+	if _, err := s.GetStudent(ctx, who, studentID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetGroupMemberships(ctx, studentID)
+}
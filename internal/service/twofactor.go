@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/ansarctica/domashka4/internal/totp"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	challengeTokenTTL  = 5 * time.Minute
+	recoveryCodeCount  = 10
+	purposeTwoFactor   = "2fa_challenge"
+	defaultTOTPIssuer  = "Student Management"
+	recoveryCodeLength = 10
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication has not been set up")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("invalid or expired login challenge")
+	ErrTwoFactorRequired    = errors.New("two-factor authentication is required for your role")
+)
+
+// TwoFactorPolicy says how authenticator apps show our accounts and which
+// roles cannot do without two-factor authentication.
+type TwoFactorPolicy struct {
+	// Issuer names the service in authenticator apps.
+	Issuer        string
+	RequiredRoles []string
+}
+
+// NewTwoFactorPolicy builds a policy, falling back to a default issuer when
+// issuer is empty. Every required role must be a known one.
+func NewTwoFactorPolicy(issuer string, requiredRoles []string) (*TwoFactorPolicy, error) {
+	for _, role := range requiredRoles {
+		if !ValidRole(role) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownRole, role)
+		}
+	}
+	if issuer == "" {
+		issuer = defaultTOTPIssuer
+	}
+	return &TwoFactorPolicy{Issuer: issuer, RequiredRoles: requiredRoles}, nil
+}
+
+// TwoFactorRequired reports whether users with role must use two-factor
+// authentication.
+func (s *Service) TwoFactorRequired(role string) bool {
+	return slices.Contains(s.twoFactor.RequiredRoles, role)
+}
+
+func twoFactorLockKeys(userID int, ip string) []lockKey {
+	return []lockKey{
+		{"2fa:" + strconv.Itoa(userID), accountLockThreshold},
+		{ipLockKey(ip), ipLockThreshold},
+	}
+}
+
+// EnrollTwoFactor starts two-factor enrollment with a new secret. Nothing
+// changes for the user until ConfirmTwoFactor sees a code from it, and
+// enrolling again before that replaces the secret.
+func (s *Service) EnrollTwoFactor(ctx context.Context, userID int) (*models.TwoFactorEnrollment, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.SetTOTPSecret(ctx, userID, secret)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTwoFactorEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(s.twoFactor.Issuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTwoFactor turns two-factor authentication on once code shows the
+// authenticator app was set up, and returns the user's recovery codes. They
+// are only ever shown here.
+func (s *Service) ConfirmTwoFactor(ctx context.Context, userID int, code, ip string) ([]string, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	if err := s.checkTOTP(ctx, userID, code, ip); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.EnableTOTP(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off, given a current code
+// or a recovery code. Users whose role requires it cannot.
+func (s *Service) DisableTwoFactor(ctx context.Context, userID int, code, ip string) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnrolled
+	}
+	if s.TwoFactorRequired(user.Role) {
+		return ErrTwoFactorRequired
+	}
+
+	if err := s.checkTwoFactorCode(ctx, userID, code, ip); err != nil {
+		return err
+	}
+	return s.repo.DisableTOTP(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replaces all of the user's recovery codes.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID int, code, ip string) ([]string, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	if err := s.checkTwoFactorCode(ctx, userID, code, ip); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CompleteTwoFactorLogin exchanges the challenge token from a password login
+// and a current or recovery code for a token pair. A challenge works once.
+func (s *Service) CompleteTwoFactorLogin(ctx context.Context, challenge, code, ip, userAgent string) (*models.AuthTokens, error) {
+	claims, err := s.parseClaims(challenge)
+	if err != nil || claims.Purpose != purposeTwoFactor {
+		return nil, ErrInvalidChallenge
+	}
+	if err := s.CheckToken(ctx, claims); err != nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	if err := s.checkTwoFactorCode(ctx, claims.UserID, code, ip); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.RevokeToken(ctx, claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.startSession(ctx, user, ip, userAgent)
+}
+
+// issueChallenge answers a successful first factor for a user who also has a
+// second one.
+func (s *Service) issueChallenge(user *models.User) (*models.AuthTokens, error) {
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	jti, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token, err := s.keys.Sign(TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(challengeTokenTTL)),
+		},
+		UserID:        user.ID,
+		Role:          user.Role,
+		Purpose:       purposeTwoFactor,
+		IssuedAtMicro: now.UnixMicro(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuthTokens{
+		ExpiresIn:         int(challengeTokenTTL.Seconds()),
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+	}, nil
+}
+
+// checkTwoFactorCode accepts either a current TOTP code or an unused
+// recovery code.
+func (s *Service) checkTwoFactorCode(ctx context.Context, userID int, code, ip string) error {
+	code = strings.TrimSpace(code)
+	if len(code) != 6 {
+		return s.checkRecoveryCode(ctx, userID, code, ip)
+	}
+	return s.checkTOTP(ctx, userID, code, ip)
+}
+
+// checkTOTP validates code against the user's secret. Every time step is
+// accepted only once, and wrong codes count towards a lockout just like
+// wrong passwords do.
+func (s *Service) checkTOTP(ctx context.Context, userID int, code, ip string) error {
+	keys := twoFactorLockKeys(userID, ip)
+	if err := s.checkLock(ctx, keys); err != nil {
+		return err
+	}
+
+	secret, err := s.repo.GetTOTPSecret(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrTwoFactorNotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(secret, strings.TrimSpace(code), time.Now())
+	if ok {
+		ok, err = s.repo.UseTOTPStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+	}
+	if !ok {
+		if err := s.recordFailure(ctx, keys); err != nil {
+			return err
+		}
+		return ErrInvalidTwoFactorCode
+	}
+
+	return s.repo.ClearLoginAttempts(ctx, []string{keys[0].key})
+}
+
+func (s *Service) checkRecoveryCode(ctx context.Context, userID int, code, ip string) error {
+	keys := twoFactorLockKeys(userID, ip)
+	if err := s.checkLock(ctx, keys); err != nil {
+		return err
+	}
+
+	ok, err := s.repo.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if err := s.recordFailure(ctx, keys); err != nil {
+			return err
+		}
+		return ErrInvalidTwoFactorCode
+	}
+
+	return s.repo.ClearLoginAttempts(ctx, []string{keys[0].key})
+}
+
+// newRecoveryCodes returns fresh recovery codes, formatted like
+// "abcde-fghij", and the hashes to store for them.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(secret[:recoveryCodeLength])
+		codes[i] = raw[:recoveryCodeLength/2] + "-" + raw[recoveryCodeLength/2:]
+		hashes[i] = hashToken(raw)
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
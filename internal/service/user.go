@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrAccountDisabled = errors.New("account is disabled")
+	ErrSelfManagement  = errors.New("admins cannot change the role of, disable or delete their own account")
+)
+
+func (s *Service) GetUsers(ctx context.Context, filter models.UserFilter) (*models.UserList, error) {
+	return s.repo.GetUsers(ctx, filter)
+}
+
+func (s *Service) GetUserAccount(ctx context.Context, id int) (*models.UserAccount, error) {
+	user, err := s.repo.GetUserAccount(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// ChangeUserRole gives the user a new role. Their tokens carry the old one,
+// so they are signed out, and API keys acting with another role are revoked.
+func (s *Service) ChangeUserRole(ctx context.Context, adminID, userID int, role string) error {
+	if !ValidRole(role) {
+		return ErrUnknownRole
+	}
+	if adminID == userID {
+		return ErrSelfManagement
+	}
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Role == role {
+		return nil
+	}
+
+	if err := s.setUserRole(ctx, userID, role); err != nil {
+		return err
+	}
+
+	return s.audit(ctx, adminID, models.AuditUserRoleChanged, userID, map[string]any{
+		"from": user.Role,
+		"to":   role,
+	})
+}
+
+// setUserRole gives a user another role and revokes what was issued under
+// the old one: their tokens, and API keys the new role no longer allows.
+func (s *Service) setUserRole(ctx context.Context, userID int, role string) error {
+	if err := s.repo.SetUserRole(ctx, userID, role); err != nil {
+		return err
+	}
+	if err := s.repo.RevokeUserTokens(ctx, userID); err != nil {
+		return err
+	}
+	return s.repo.RevokeAPIKeysExceptRole(ctx, userID, role)
+}
+
+// SetUserDisabled disables or re-enables an account. A disabled user cannot
+// log in, their tokens and sessions are revoked and their API keys stop
+// working until they are enabled again.
+func (s *Service) SetUserDisabled(ctx context.Context, adminID, userID int, disabled bool) error {
+	if adminID == userID {
+		return ErrSelfManagement
+	}
+
+	err := s.repo.SetUserDisabled(ctx, userID, disabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	action := models.AuditUserEnabled
+	if disabled {
+		action = models.AuditUserDisabled
+		if err := s.repo.RevokeUserTokens(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return s.audit(ctx, adminID, action, userID, nil)
+}
+
+// ForcePasswordReset removes the user's password, signs them out and emails
+// them a link to choose a new one.
+func (s *Service) ForcePasswordReset(ctx context.Context, adminID, userID int) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.ClearUserPassword(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.repo.RevokeUserTokens(ctx, userID); err != nil {
+		return err
+	}
+	err = s.sendPasswordReset(ctx, user,
+		"An administrator has reset the password of your account.",
+		"Until you do, you cannot log in with a password.")
+	if err != nil {
+		return err
+	}
+
+	return s.audit(ctx, adminID, models.AuditUserPasswordReset, userID, nil)
+}
+
+func (s *Service) DeleteUser(ctx context.Context, adminID, userID int) error {
+	if adminID == userID {
+		return ErrSelfManagement
+	}
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteUser(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.audit(ctx, adminID, models.AuditUserDeleted, userID, map[string]any{
+		"email": user.Email,
+		"role":  user.Role,
+	})
+}
+
+func (s *Service) GetAuditLog(ctx context.Context, targetUserID *int, limit, offset int) ([]models.AuditEntry, error) {
+	return s.repo.GetAuditLog(ctx, targetUserID, limit, offset)
+}
+
+func (s *Service) audit(ctx context.Context, adminID int, action string, targetUserID int, details map[string]any) error {
+	return s.repo.CreateAuditEntry(ctx, &models.AuditEntry{
+		AdminID:      adminID,
+		Action:       action,
+		TargetUserID: &targetUserID,
+		Details:      details,
+	})
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+)
+
+var (
+	ErrInvalidStudent    = errors.New("invalid student")
+	ErrInvalidSchedule   = errors.New("invalid schedule")
+	ErrInvalidAttendance = errors.New("invalid attendance record")
+	ErrScheduleNotFound  = errors.New("schedule not found")
+)
+
+func validateStudent(s *models.Student) error {
+	switch {
+	case s.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidStudent)
+	case len([]rune(s.Name)) > 100:
+		return fmt.Errorf("%w: name is longer than 100 characters", ErrInvalidStudent)
+	case s.BirthDate.IsZero():
+		return fmt.Errorf("%w: birth_date is required", ErrInvalidStudent)
+	case s.BirthDate.After(time.Now()):
+		return fmt.Errorf("%w: birth_date is in the future", ErrInvalidStudent)
+	case s.Gender != "M" && s.Gender != "F":
+		return fmt.Errorf("%w: gender must be M or F", ErrInvalidStudent)
+	case s.GroupID <= 0:
+		return fmt.Errorf("%w: group_id is required", ErrInvalidStudent)
+	case len([]rune(s.Major)) > 100:
+		return fmt.Errorf("%w: major is longer than 100 characters", ErrInvalidStudent)
+	case s.CourseYear < 1:
+		return fmt.Errorf("%w: course_year must be at least 1", ErrInvalidStudent)
+	}
+	return nil
+}
+
+// validateSchedule compares times of day only; the date part of schedule
+// times means nothing.
+func validateSchedule(s *models.Schedule) error {
+	switch {
+	case s.GroupID <= 0:
+		return fmt.Errorf("%w: group_id is required", ErrInvalidSchedule)
+	case s.Subject == "":
+		return fmt.Errorf("%w: subject is required", ErrInvalidSchedule)
+	case s.EndTime.Format(time.TimeOnly) <= s.StartTime.Format(time.TimeOnly):
+		return fmt.Errorf("%w: end_time must be after start_time", ErrInvalidSchedule)
+	}
+	return nil
+}
+
+func validateAttendance(a *models.Attendance) error {
+	switch {
+	case a.StudentID <= 0:
+		return fmt.Errorf("%w: student_id is required", ErrInvalidAttendance)
+	case a.SubjectName == "":
+		return fmt.Errorf("%w: subject_name is required", ErrInvalidAttendance)
+	case a.VisitDay.IsZero():
+		return fmt.Errorf("%w: visit_day is required", ErrInvalidAttendance)
+	}
+	return nil
+}
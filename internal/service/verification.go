@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+var (
+	ErrEmailNotVerified         = errors.New("email is not verified")
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+)
+
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	userID, err := s.repo.UseUserToken(ctx, models.TokenPurposeEmailVerification, hashToken(token))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.repo.MarkEmailVerified(ctx, userID)
+}
+
+// ResendVerification mails a fresh verification link to an unverified
+// account. Like ForgotPassword it does not reveal whether the email exists.
+func (s *Service) ResendVerification(ctx context.Context, email string) error {
+	user, err := s.repo.GetUserByEmail(ctx, normalizeEmail(email))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return nil
+	}
+
+	return s.sendVerificationEmail(ctx, user.ID, user.Email)
+}
+
+func (s *Service) sendVerificationEmail(ctx context.Context, userID int, email string) error {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.CreateUserToken(ctx, userID, models.TokenPurposeEmailVerification, hash, time.Now().Add(emailVerificationTTL))
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"Confirm your email address by opening this link within %s:\n%s\n\n"+
+			"If you did not create an account, ignore this email.",
+		emailVerificationTTL, s.links.apiLink("/auth/verify", token),
+	)
+	s.sendMailAsync(email, "Confirm your email address", body)
+
+	return nil
+}
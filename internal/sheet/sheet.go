@@ -0,0 +1,157 @@
+// Package sheet reads tables out of CSV files and XLSX workbooks, and writes
+// them as CSV, XLSX or JSON Lines.
+package sheet
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported file format, upload a .csv or .xlsx file")
+
+// Read returns the rows of the file named name, a CSV file or an XLSX
+// workbook going by its extension. Only the first sheet of a workbook is
+// read. Its cells are returned as stored rather than as Excel displays
+// them, except that dates come as YYYY-MM-DD, with the time after them if
+// there is one.
+func Read(r io.Reader, name string) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return readCSV(r)
+	case ".xlsx":
+		return readXLSX(r)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+func readCSV(r io.Reader) ([][]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading csv: %w", err)
+	}
+
+	// Excel starts the CSV files it saves as UTF-8 with a byte order mark.
+	if len(rows) > 0 && len(rows[0]) > 0 {
+		rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+	}
+	return rows, nil
+}
+
+func readXLSX(r io.Reader) ([][]string, error) {
+	f, err := excelize.OpenReader(r, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, fmt.Errorf("reading xlsx: %w", err)
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, nil
+	}
+
+	rows, err := f.GetRows(sheets[0])
+	if err != nil {
+		return nil, fmt.Errorf("reading xlsx: %w", err)
+	}
+	if err := formatDates(f, sheets[0], rows); err != nil {
+		return nil, fmt.Errorf("reading xlsx: %w", err)
+	}
+	return rows, nil
+}
+
+// formatDates replaces the serial numbers Excel keeps dates as with the
+// dates themselves, in the cells whose number format shows a date.
+func formatDates(f *excelize.File, sheet string, rows [][]string) error {
+	props, err := f.GetWorkbookProps()
+	if err != nil {
+		return err
+	}
+	date1904 := props.Date1904 != nil && *props.Date1904
+
+	dateStyles := make(map[int]bool)
+	for i, row := range rows {
+		for j, value := range row {
+			serial, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+
+			cell, err := excelize.CoordinatesToCellName(j+1, i+1)
+			if err != nil {
+				return err
+			}
+			styleID, err := f.GetCellStyle(sheet, cell)
+			if err != nil {
+				return err
+			}
+			isDate, ok := dateStyles[styleID]
+			if !ok {
+				style, err := f.GetStyle(styleID)
+				if err != nil {
+					return err
+				}
+				isDate = isDateFormat(style)
+				dateStyles[styleID] = isDate
+			}
+			if !isDate {
+				continue
+			}
+
+			t, err := excelize.ExcelDateToTime(serial, date1904)
+			if err != nil {
+				continue
+			}
+			if t.Equal(t.Truncate(24 * time.Hour)) {
+				row[j] = t.Format(time.DateOnly)
+			} else {
+				row[j] = t.Format(time.DateTime)
+			}
+		}
+	}
+	return nil
+}
+
+// isDateFormat reports whether a cell style shows numbers as dates or times:
+// one of the built-in date formats, or a custom format with date or time
+// codes outside its quoted text, escapes and [colour] or [locale] tags.
+func isDateFormat(style *excelize.Style) bool {
+	switch id := style.NumFmt; {
+	case id >= 14 && id <= 22, id >= 27 && id <= 36, id >= 45 && id <= 47, id >= 50 && id <= 58:
+		return true
+	}
+	if style.CustomNumFmt == nil {
+		return false
+	}
+
+	code := *style.CustomNumFmt
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; c {
+		case '"':
+			if end := strings.IndexByte(code[i+1:], '"'); end >= 0 {
+				i += end + 1
+			}
+		case '\\':
+			i++
+		case '[':
+			if end := strings.IndexByte(code[i:], ']'); end >= 0 {
+				i += end
+			}
+		case 'y', 'Y', 'd', 'D', 'h', 'H', 's', 'S':
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package sheet
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestReadXLSXDates(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customDate := `dd"."mm"."yyyy`
+	customStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &customDate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dateTime := "yyyy-mm-dd hh:mm"
+	dateTimeStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &dateTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quoted := `0" days"`
+	quotedStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &quoted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	twoDecimals, err := f.NewStyle(&excelize.Style{NumFmt: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cells := []struct {
+		cell  string
+		value any
+		style int
+	}{
+		{"A1", "name", 0},
+		{"B1", "birth_date", 0},
+		{"C1", "group_id", 0},
+		{"A2", "Ann", 0},
+		{"B2", time.Date(2005, 3, 14, 0, 0, 0, 0, time.UTC), dateStyle},
+		{"C2", 3, twoDecimals},
+		{"A3", "Bob", 0},
+		{"B3", time.Date(2004, 12, 1, 0, 0, 0, 0, time.UTC), customStyle},
+		{"C3", 45000, quotedStyle},
+		{"A4", "Cid", 0},
+		{"B4", time.Date(2006, 1, 2, 15, 4, 0, 0, time.UTC), dateTimeStyle},
+		{"C4", "2006-01-02", 0},
+	}
+	for _, c := range cells {
+		if err := f.SetCellValue("Sheet1", c.cell, c.value); err != nil {
+			t.Fatal(err)
+		}
+		if c.style != 0 {
+			if err := f.SetCellStyle("Sheet1", c.cell, c.cell, c.style); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := Read(&buf, "students.XLSX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"name", "birth_date", "group_id"},
+		{"Ann", "2005-03-14", "3"},
+		{"Bob", "2004-12-01", "45000"},
+		{"Cid", "2006-01-02 15:04:00", "2006-01-02"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Read() = %q, want %q", rows, want)
+	}
+}
+
+func TestIsDateFormat(t *testing.T) {
+	custom := func(code string) *string { return &code }
+
+	tests := []struct {
+		name  string
+		style excelize.Style
+		want  bool
+	}{
+		{"general", excelize.Style{}, false},
+		{"built-in date", excelize.Style{NumFmt: 14}, true},
+		{"built-in time", excelize.Style{NumFmt: 21}, true},
+		{"built-in number", excelize.Style{NumFmt: 4}, false},
+		{"custom date", excelize.Style{CustomNumFmt: custom("dd/mm/yyyy")}, true},
+		{"custom number", excelize.Style{CustomNumFmt: custom("#,##0.00")}, false},
+		{"date letters in quotes", excelize.Style{CustomNumFmt: custom(`0" days"`)}, false},
+		{"escaped date letter", excelize.Style{CustomNumFmt: custom(`0\d`)}, false},
+		{"colour tag", excelize.Style{CustomNumFmt: custom("[Red]0.00")}, false},
+		{"locale tag and date", excelize.Style{CustomNumFmt: custom("[$-409]d-mmm-yy")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDateFormat(&tt.style); got != tt.want {
+				t.Errorf("isDateFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// Package sso signs users in through an external OpenID Connect provider
+// using the authorization code flow with PKCE.
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ansarctica/domashka4/internal/models"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrInvalidIDToken = errors.New("identity provider returned an invalid ID token")
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// RoleClaim names the ID token claim, a string or a list of strings,
+	// whose values are looked up in RoleMap.
+	RoleClaim string
+	// RoleMap maps provider claim values to our roles.
+	RoleMap map[string]string
+	// DefaultRole is given to new users none of whose claim values map to a
+	// role. When empty, such users are turned away.
+	DefaultRole string
+}
+
+// Identity is what the provider vouches for about a signed-in user.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// Role is the mapped role, or empty if no claim value matched.
+	Role string
+}
+
+type Provider struct {
+	cfg      Config
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewProvider fetches the provider's discovery document from the issuer.
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+
+	return &Provider{
+		cfg: cfg,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+func (p *Provider) DefaultRole() string {
+	return p.cfg.DefaultRole
+}
+
+// AuthCodeURL is where the user agent is sent to sign in. The verifier's
+// S256 challenge goes along with it.
+func (p *Provider) AuthCodeURL(state, verifier, nonce string) string {
+	return p.oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce))
+}
+
+// Exchange redeems an authorization code and checks the ID token that comes
+// back, including that it carries nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrInvalidIDToken
+	}
+
+	idToken, err := p.verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{Subject: idToken.Subject}
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Role = p.mapRole(claims[p.cfg.RoleClaim])
+	return identity, nil
+}
+
+// rolePriority decides between several matching claim values, so a user in
+// both a staff and a student group gets the staff role.
+var rolePriority = []string{models.RoleAdmin, models.RoleTeacher, models.RoleParent, models.RoleStudent}
+
+func (p *Provider) mapRole(claim any) string {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = []string{v}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	matched := make(map[string]bool)
+	for _, v := range values {
+		if role, ok := p.cfg.RoleMap[v]; ok {
+			matched[role] = true
+		}
+	}
+	for _, role := range rolePriority {
+		if matched[role] {
+			return role
+		}
+	}
+	return ""
+}
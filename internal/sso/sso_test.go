@@ -0,0 +1,154 @@
+package sso_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ansarctica/domashka4/internal/sso"
+	"github.com/ansarctica/domashka4/internal/sso/ssotest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const verifier = "a-verifier-that-is-long-enough-for-pkce-0123456789abcdef"
+
+func newProvider(t *testing.T, mock *ssotest.Provider) *sso.Provider {
+	t.Helper()
+
+	provider, err := sso.NewProvider(context.Background(), sso.Config{
+		Issuer:      mock.URL,
+		ClientID:    ssotest.ClientID,
+		RedirectURL: "http://localhost/callback",
+		RoleClaim:   "groups",
+		RoleMap: map[string]string{
+			"staff":    "teacher",
+			"students": "student",
+			"it":       "admin",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		want   sso.Identity
+	}{
+		{
+			name:   "verified email and single role",
+			claims: jwt.MapClaims{"sub": "u1", "email": "ann@example.com", "email_verified": true, "groups": "students"},
+			want:   sso.Identity{Subject: "u1", Email: "ann@example.com", EmailVerified: true, Role: "student"},
+		},
+		{
+			name:   "staff role wins over student",
+			claims: jwt.MapClaims{"sub": "u2", "email": "bob@example.com", "email_verified": true, "groups": []any{"students", "staff", "other"}},
+			want:   sso.Identity{Subject: "u2", Email: "bob@example.com", EmailVerified: true, Role: "teacher"},
+		},
+		{
+			name:   "admin wins over teacher",
+			claims: jwt.MapClaims{"sub": "u3", "groups": []any{"staff", "it"}},
+			want:   sso.Identity{Subject: "u3", Role: "admin"},
+		},
+		{
+			name:   "unverified email and no matching role",
+			claims: jwt.MapClaims{"sub": "u4", "email": "eve@example.com", "email_verified": false, "groups": []any{"guests"}},
+			want:   sso.Identity{Subject: "u4", Email: "eve@example.com"},
+		},
+		{
+			name:   "no role claim",
+			claims: jwt.MapClaims{"sub": "u5", "email": "dan@example.com", "email_verified": true},
+			want:   sso.Identity{Subject: "u5", Email: "dan@example.com", EmailVerified: true},
+		},
+		{
+			name:   "role claim of the wrong type",
+			claims: jwt.MapClaims{"sub": "u6", "groups": 7},
+			want:   sso.Identity{Subject: "u6"},
+		},
+	}
+
+	mock := ssotest.NewProvider(t)
+	provider := newProvider(t, mock)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, code := mock.Authorize(provider.AuthCodeURL("state", verifier, "nonce"), tt.claims)
+
+			got, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("Exchange() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		// verifier and nonce are what the code is redeemed with; the
+		// sign-in is started with the package verifier and "nonce".
+		verifier string
+		nonce    string
+		claims   jwt.MapClaims
+		// wantErr is matched with errors.Is when set.
+		wantErr error
+	}{
+		{
+			name:     "wrong PKCE verifier",
+			verifier: "not-the-verifier-the-challenge-was-made-from-0123456789",
+			nonce:    "nonce",
+			claims:   jwt.MapClaims{"sub": "u1"},
+		},
+		{
+			name:     "nonce mismatch",
+			verifier: verifier,
+			nonce:    "another-nonce",
+			claims:   jwt.MapClaims{"sub": "u1"},
+			wantErr:  sso.ErrInvalidIDToken,
+		},
+		{
+			name:     "token for another client",
+			verifier: verifier,
+			nonce:    "nonce",
+			claims:   jwt.MapClaims{"sub": "u1", "aud": "someone-else"},
+			wantErr:  sso.ErrInvalidIDToken,
+		},
+	}
+
+	mock := ssotest.NewProvider(t)
+	provider := newProvider(t, mock)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, code := mock.Authorize(provider.AuthCodeURL("state", verifier, "nonce"), tt.claims)
+
+			got, err := provider.Exchange(context.Background(), code, tt.verifier, tt.nonce)
+			if err == nil {
+				t.Fatalf("Exchange() = %+v, want an error", *got)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exchange() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeOnlyOnce(t *testing.T) {
+	mock := ssotest.NewProvider(t)
+	provider := newProvider(t, mock)
+	ctx := context.Background()
+
+	_, code := mock.Authorize(provider.AuthCodeURL("state", verifier, "nonce"), jwt.MapClaims{"sub": "u1"})
+	if _, err := provider.Exchange(ctx, code, verifier, "nonce"); err != nil {
+		t.Fatalf("first Exchange() error = %v", err)
+	}
+	if _, err := provider.Exchange(ctx, code, verifier, "nonce"); err == nil {
+		t.Error("second Exchange() of the same code succeeded")
+	}
+}
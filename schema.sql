@@ -1,3 +1,6 @@
+-- Trigram matching for fuzzy student name search.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 CREATE TABLE subjects (
     name VARCHAR(50) PRIMARY KEY
 );
@@ -15,9 +18,35 @@ CREATE TABLE students (
     group_id INT REFERENCES groups(id),
     
     major VARCHAR(100),
-    course_year INT
+    course_year INT,
+
+    -- Where the student is in their studies; only enrolled students count
+    -- in rankings and can be graded or marked present.
+    status VARCHAR(20) NOT NULL DEFAULT 'enrolled'
+        CHECK (status IN ('applicant', 'enrolled', 'academic_leave', 'graduated', 'expelled', 'withdrawn')),
+
+    -- Set when the student is archived; archived students are hidden
+    -- everywhere but can be restored until they are purged.
+    deleted_at TIMESTAMPTZ
+);
+
+CREATE INDEX students_name_trgm_idx ON students USING GIN (name gin_trgm_ops);
+
+-- Which group each student was in when. valid_from is NULL for the group a
+-- student started out in, valid_to is NULL for their current group, and a
+-- membership covers valid_from up to but not including valid_to.
+CREATE TABLE group_memberships (
+    id SERIAL PRIMARY KEY,
+    student_id INT NOT NULL REFERENCES students(id) ON DELETE CASCADE,
+    group_id INT NOT NULL REFERENCES groups(id),
+    valid_from DATE,
+    valid_to DATE,
+    CHECK (valid_from < valid_to)
 );
 
+CREATE UNIQUE INDEX group_memberships_current_idx ON group_memberships (student_id) WHERE valid_to IS NULL;
+CREATE INDEX group_memberships_group_id_idx ON group_memberships (group_id);
+
 CREATE TABLE attendance (
     id SERIAL PRIMARY KEY,
     student_id INT REFERENCES students(id) ON DELETE CASCADE,
@@ -38,10 +67,165 @@ CREATE TABLE schedule (
     end_time TIME
 );
 
+-- Self-registration only creates students; promote the first administrator by hand:
+-- UPDATE users SET role = 'admin' WHERE email = '...';
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
-    email VARCHAR(255) UNIQUE,
-    password_hash VARCHAR(255)
+    email VARCHAR(255),
+    password_hash VARCHAR(255),
+    role VARCHAR(20) NOT NULL DEFAULT 'student' CHECK (role IN ('admin', 'teacher', 'student', 'parent')),
+    email_verified_at TIMESTAMPTZ,
+    tokens_revoked_at TIMESTAMPTZ,
+    student_id INT UNIQUE REFERENCES students(id) ON DELETE SET NULL,
+    oidc_subject VARCHAR(255) UNIQUE,
+    totp_secret VARCHAR(64),
+    totp_enabled_at TIMESTAMPTZ,
+    -- Last TOTP time step accepted, so a code cannot be used twice.
+    totp_last_step BIGINT NOT NULL DEFAULT 0,
+    disabled_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Emails are unique whatever their case.
+CREATE UNIQUE INDEX users_email_idx ON users (lower(email));
+
+CREATE TABLE teachers (
+    id SERIAL PRIMARY KEY,
+    user_id INT UNIQUE REFERENCES users(id) ON DELETE SET NULL,
+    name VARCHAR(100) NOT NULL
+);
+
+-- A teacher may only record grades, assignments and attendance for the
+-- (subject, group) pairs listed here.
+CREATE TABLE teaching_assignments (
+    teacher_id INT NOT NULL REFERENCES teachers(id) ON DELETE CASCADE,
+    subject_name VARCHAR(50) NOT NULL REFERENCES subjects(name),
+    group_id INT NOT NULL REFERENCES groups(id),
+    PRIMARY KEY (teacher_id, subject_name, group_id)
+);
+
+CREATE TABLE guardian_students (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    student_id INT NOT NULL REFERENCES students(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, student_id)
+);
+
+CREATE TABLE guardian_invitations (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    student_ids INT[] NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    invited_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ
+);
+
+-- Failed logins are counted per key, either "email:<address>" or "ip:<address>",
+-- so unknown emails are throttled exactly like registered ones.
+CREATE TABLE login_attempts (
+    key VARCHAR(300) PRIMARY KEY,
+    failed_count INT NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ
+);
+
+CREATE TABLE refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- One row per login; its refresh token family carries it across refreshes.
+CREATE TABLE sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id VARCHAR(64) NOT NULL UNIQUE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    terminated_at TIMESTAMPTZ
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+
+CREATE TABLE revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('admin', 'teacher', 'student', 'parent')),
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE TABLE user_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(30) NOT NULL CHECK (purpose IN ('password_reset', 'email_verification')),
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    used_at TIMESTAMPTZ
+);
+
+-- What admins did to user accounts. Ids are kept without foreign keys so
+-- entries outlive the users they mention.
+CREATE TABLE admin_audit_log (
+    id SERIAL PRIMARY KEY,
+    admin_id INT NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    target_user_id INT,
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX admin_audit_log_target_user_id_idx ON admin_audit_log (target_user_id);
+
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ,
+    UNIQUE (user_id, code_hash)
+);
+
+-- In-flight single sign-on attempts, keyed by the hash of their state.
+CREATE TABLE oidc_logins (
+    state_hash VARCHAR(64) PRIMARY KEY,
+    code_verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- JWT signing keys generated on rotation, shared by all instances. A key
+-- signs from not_before on and verifies tokens until retires_at. The private
+-- keys are stored as is, so the database needs the same care as key files.
+CREATE TABLE jwt_signing_keys (
+    kid VARCHAR(64) PRIMARY KEY,
+    private_key BYTEA NOT NULL,
+    not_before TIMESTAMPTZ NOT NULL,
+    retires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 
 CREATE TABLE assignments (
@@ -61,6 +245,20 @@ CREATE TABLE grades (
     mark INT CHECK (mark >= 0 AND mark <= 100)
 );
 
+-- Every change of a student's status, with why and from when.
+CREATE TABLE student_status_changes (
+    id SERIAL PRIMARY KEY,
+    student_id INT NOT NULL REFERENCES students(id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    reason TEXT NOT NULL,
+    effective_date DATE NOT NULL,
+    changed_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX student_status_changes_student_id_idx ON student_status_changes (student_id);
+
 INSERT INTO subjects (name) VALUES
 ('Physics'), ('Calculus'), ('Drawing'), ('Chemistry'), 
 ('Physical Education'), ('Programming'), ('History'), 
@@ -83,6 +281,9 @@ INSERT INTO students (name, birth_date, gender, group_id, major, course_year) VA
 ('Irina', '2005-10-05', 'F', 4, 'Sociology', 3),
 ('Marat', '2005-02-28', 'M', 4, 'Sociology', 3);
 
+INSERT INTO group_memberships (student_id, group_id)
+SELECT id, group_id FROM students;
+
 INSERT INTO schedule (group_id, subject_name, start_time, end_time) VALUES
 (1, 'Physics', '09:00', '10:30'),
 (1, 'Calculus', '10:45', '12:15'),